@@ -0,0 +1,269 @@
+package cache
+
+import (
+	"encoding/json"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var purchasesBucket = []byte("purchases")
+var productsBucket = []byte("products")
+var vouchersBucket = []byte("vouchers")
+var syncBucket = []byte("sync")
+
+// Cache is a local database with the purchases, products and vouchers
+// fetched by previous runs. It makes it possible to only fetch what has
+// changed since the last run, and to list them without logging in.
+type Cache struct {
+	db *bolt.DB
+}
+
+func Open(filename string) (*Cache, error) {
+	// Only one run at a time can use the cache, a second run fails
+	// instead of waiting forever.
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{purchasesBucket, productsBucket, vouchersBucket, syncBucket} {
+			_, err := tx.CreateBucketIfNotExists(b)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Cache{db: db}, nil
+}
+
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Purchases returns the cached purchases made between the dates,
+// both dates included.
+func (c *Cache) Purchases(from util.Date, to util.Date) (*izettle.Purchases, error) {
+	year, month, day := to.Time().Date()
+	end := util.DateFromTime(time.Date(year, month, day+1, 0, 0, 0, 0, to.Time().Location()))
+	purchases := []izettle.Purchase{}
+	err := c.forEach(purchasesBucket, func(data []byte) error {
+		cp := cachedPurchase{}
+		err := json.Unmarshal(data, &cp)
+		if err != nil {
+			return err
+		}
+		p := cp.Purchase
+		p.Timestamp = util.DateFromTime(cp.Timestamp)
+		if !p.Timestamp.Before(from) && p.Timestamp.Before(end) {
+			purchases = append(purchases, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(purchases, func(i, j int) bool {
+		return purchases[i].Timestamp.Before(purchases[j].Timestamp)
+	})
+	return &izettle.Purchases{Purchases: purchases}, nil
+}
+
+// cachedPurchase keeps the time of the purchase, util.Date only
+// marshals the date.
+type cachedPurchase struct {
+	Purchase  izettle.Purchase
+	Timestamp time.Time
+}
+
+func (c *Cache) PutPurchases(purchases []izettle.Purchase) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(purchasesBucket)
+		for _, p := range purchases {
+			data, err := json.Marshal(cachedPurchase{Purchase: p, Timestamp: p.Timestamp.Time()})
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(p.PurchaseUUID), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Cache) Products() ([]izettle.Product, error) {
+	products := []izettle.Product{}
+	err := c.forEach(productsBucket, func(data []byte) error {
+		p := izettle.Product{}
+		err := json.Unmarshal(data, &p)
+		if err != nil {
+			return err
+		}
+		products = append(products, p)
+		return nil
+	})
+	return products, err
+}
+
+// PutProducts replaces all cached products, since products which are
+// removed from the library are not returned by iZettle.
+func (c *Cache) PutProducts(products []izettle.Product) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(productsBucket)
+		if err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(productsBucket)
+		if err != nil {
+			return err
+		}
+		for _, p := range products {
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(p.UUID), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Vouchers returns the cached vouchers between the dates, both dates included.
+// If any fiscal years are given only the vouchers of those years are returned.
+func (c *Cache) Vouchers(fromDate util.Date, toDate util.Date, years []visma.FiscalYear) ([]visma.Voucher, error) {
+	vouchers := []visma.Voucher{}
+	err := c.forEach(vouchersBucket, func(data []byte) error {
+		v := visma.Voucher{}
+		err := json.Unmarshal(data, &v)
+		if err != nil {
+			return err
+		}
+		if v.VoucherDate.Before(fromDate) || v.VoucherDate.After(toDate) {
+			return nil
+		}
+		if len(years) == 0 {
+			vouchers = append(vouchers, v)
+			return nil
+		}
+		for _, year := range years {
+			if year.Contains(v.VoucherDate) {
+				vouchers = append(vouchers, v)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(vouchers, func(i, j int) bool {
+		return vouchers[i].VoucherDate.Before(vouchers[j].VoucherDate)
+	})
+	return vouchers, nil
+}
+
+func (c *Cache) PutVouchers(vouchers []visma.Voucher) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(vouchersBucket)
+		for _, v := range vouchers {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(v.ID), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReplaceVouchers replaces the cached vouchers of the fiscal year, so that
+// vouchers which have been deleted in visma are removed.
+func (c *Cache) ReplaceVouchers(year visma.FiscalYear, vouchers []visma.Voucher) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(vouchersBucket)
+		// Keys can not be deleted while iterating over the bucket
+		var deleted [][]byte
+		err := b.ForEach(func(key, data []byte) error {
+			v := visma.Voucher{}
+			err := json.Unmarshal(data, &v)
+			if err != nil {
+				return err
+			}
+			if year.Contains(v.VoucherDate) {
+				deleted = append(deleted, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range deleted {
+			err = b.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		for _, v := range vouchers {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(v.ID), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LastSync returns when the key was last synced, or false if it never was
+func (c *Cache) LastSync(key string) (time.Time, bool) {
+	var t time.Time
+	err := c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(syncBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		return t.UnmarshalText(data)
+	})
+	if err != nil || t.IsZero() {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func (c *Cache) SetLastSync(key string, t time.Time) error {
+	data, err := t.MarshalText()
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(syncBucket).Put([]byte(key), data)
+	})
+}
+
+func (c *Cache) forEach(bucket []byte, fn func(data []byte) error) error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, data []byte) error {
+			return fn(data)
+		})
+	})
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceVouchers(t *testing.T) {
+	c, err := Open(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	year2019 := visma.FiscalYear{ID: "2019", StartDate: util.DateFromStringOrPanic("2019-01-01"), EndDate: util.DateFromStringOrPanic("2019-12-31")}
+	year2020 := visma.FiscalYear{ID: "2020", StartDate: util.DateFromStringOrPanic("2020-01-01"), EndDate: util.DateFromStringOrPanic("2020-12-31")}
+	voucher := func(id string, date string, text string) visma.Voucher {
+		return visma.Voucher{ID: id, VoucherDate: util.DateFromStringOrPanic(date), VoucherText: text}
+	}
+	err = c.PutVouchers([]visma.Voucher{
+		voucher("a", "2019-12-30", "old year"),
+		voucher("b", "2020-10-13", "kept"),
+		voucher("c", "2020-10-14", "deleted in visma"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.ReplaceVouchers(year2020, []visma.Voucher{voucher("b", "2020-10-13", "changed")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vouchers, err := c.Vouchers(util.DateFromStringOrPanic("2019-01-01"), util.DateFromStringOrPanic("2020-12-31"), []visma.FiscalYear{year2019, year2020})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a old year", "b changed"}
+	if len(vouchers) != len(want) {
+		t.Fatalf("expected %d vouchers, got %d", len(want), len(vouchers))
+	}
+	for i, v := range vouchers {
+		if got := v.ID + " " + v.VoucherText; got != want[i] {
+			t.Errorf("expected %s, got %s", want[i], got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"time"
+)
+
+const purchasesFromKey = "purchases-from"
+const purchasesToKey = "purchases-to"
+
+// SyncPurchases fetches the purchases which are not already cached and
+// returns all purchases between the dates. The last cached day is always
+// fetched again since it might not have been finished when it was cached.
+func (c *Cache) SyncPurchases(iz *izettle.Client, from util.Date, to util.Date) (*izettle.Purchases, error) {
+	fetchFrom, fetchTo := from, to
+	coveredFrom, hasFrom := c.LastSync(purchasesFromKey)
+	coveredTo, hasTo := c.LastSync(purchasesToKey)
+	if hasFrom && hasTo {
+		if !from.Time().Before(coveredFrom) {
+			fetchFrom = util.DateFromTime(coveredTo.AddDate(0, 0, -1))
+			if fetchFrom.Before(from) {
+				fetchFrom = from
+			}
+		} else {
+			coveredFrom = from.Time()
+		}
+		// Fetch up to the end of what is cached, so that the cached
+		// dates are never split in two.
+		if to.Time().Before(coveredTo) {
+			fetchTo = util.DateFromTime(coveredTo)
+		}
+	} else {
+		coveredFrom = from.Time()
+	}
+
+	purchases, err := iz.Purchases(fetchFrom, fetchTo)
+	if err != nil {
+		return nil, err
+	}
+	err = c.PutPurchases(purchases.Purchases)
+	if err != nil {
+		return nil, err
+	}
+	err = c.SetLastSync(purchasesFromKey, coveredFrom)
+	if err != nil {
+		return nil, err
+	}
+	err = c.SetLastSync(purchasesToKey, fetchTo.Time())
+	if err != nil {
+		return nil, err
+	}
+	return c.Purchases(from, to)
+}
+
+// SyncProducts fetches the products from iZettle and replaces the cached ones
+func (c *Cache) SyncProducts(iz *izettle.Client) ([]izettle.Product, error) {
+	products, err := iz.Products()
+	if err != nil {
+		return nil, err
+	}
+	err = c.PutProducts(products)
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// fullVoucherSync is how often the vouchers of a fiscal year are fetched in
+// full, since vouchers deleted in visma are not returned when only the
+// changed vouchers are fetched.
+const fullVoucherSync = 24 * time.Hour
+
+// SyncVouchers fetches the vouchers which have been created or changed since
+// the last sync of each fiscal year and returns all vouchers between the dates.
+// A fiscal year which has not been fetched in full during the last day is
+// fetched in full and replaces the cached vouchers of the year.
+func (c *Cache) SyncVouchers(vi *visma.Client, from util.Date, to util.Date, years []visma.FiscalYear) ([]visma.Voucher, error) {
+	for _, year := range years {
+		if !year.Overlaps(from, to) {
+			continue
+		}
+		key := "vouchers-" + year.ID
+		fullKey := "vouchers-full-" + year.ID
+		// Vouchers created while we fetch must be fetched again next time,
+		// and the clock of visma might not be the same as ours.
+		started := time.Now().UTC().Add(-5 * time.Minute)
+		lastSync, ok := c.LastSync(key)
+		lastFullSync, hasFull := c.LastSync(fullKey)
+		if ok && hasFull && time.Since(lastFullSync) < fullVoucherSync {
+			vouchers, err := vi.FindVouchers(year.ID, visma.NewQuery().Filter("ModifiedUtc", visma.Ge, lastSync))
+			if err != nil {
+				return nil, err
+			}
+			err = c.PutVouchers(vouchers)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			vouchers, err := vi.FindVouchers(year.ID, nil)
+			if err != nil {
+				return nil, err
+			}
+			err = c.ReplaceVouchers(year, vouchers)
+			if err != nil {
+				return nil, err
+			}
+			err = c.SetLastSync(fullKey, started)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := c.SetLastSync(key, started)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.Vouchers(from, to, years)
+}
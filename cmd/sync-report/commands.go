@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"izettle-daily-reports/generate"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/vault"
+	"izettle-daily-reports/visma"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func runSync(config Config, args []string) {
+	match, pendingVouchers := prepareImport(config, !config.DryRun)
+	if match == nil {
+		return
+	}
+	if config.DryRun {
+		fmt.Println()
+		fmt.Println("This was a dry run so no new vouchers where uploaded.")
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("Have you checked that all the vouchers and the summary looks correct? Type 'yes' to confirm.")
+	confirmation := ""
+	_, err := fmt.Scanln(&confirmation)
+	handleError(err)
+	if confirmation != "yes" {
+		handleError(fmt.Errorf("Aborting..."))
+	}
+	uploadVouchers(config, match.vi, match.matcher, match.vouchers, pendingVouchers)
+}
+
+func runPlan(config Config, args []string) {
+	planFile := planFileArg(args)
+	match, pendingVouchers := prepareImport(config, true)
+	if match == nil {
+		return
+	}
+	fmt.Println()
+	fmt.Printf("Writing plan to %s... ", planFile)
+	plan := generate.NewPlan(config.VismaEnvironment.Name, match.fromDate, match.toDate, match.vouchers, pendingVouchers)
+	err := plan.Write(planFile, pendingVouchers)
+	handleError(err)
+	fmt.Println("DONE")
+	fmt.Printf("Review the plan and upload it with: sync-report apply %s\n", planFile)
+}
+
+// runApply uploads the vouchers of a plan made by the plan command, as long
+// as nothing has changed in visma since the plan was made.
+func runApply(config Config, args []string) {
+	requireOnline(config)
+	planFile := planFileArg(args)
+	fmt.Printf("Reading plan %s... ", planFile)
+	plan, err := generate.ReadPlan(planFile)
+	handleError(err)
+	if plan.Environment != config.VismaEnvironment.Name {
+		fmt.Println()
+		handleError(fmt.Errorf("the plan was made for the environment %s and not %s", plan.Environment, config.VismaEnvironment.Name))
+	}
+	pendingVouchers, err := plan.PendingVouchers(planFile)
+	handleError(err)
+	fmt.Println("DONE")
+	fmt.Println()
+
+	fmt.Println("Logging in:")
+	vi := loginVisma(config)
+	fmt.Println()
+
+	db := openCache(config)
+	defer db.Close()
+	fmt.Printf("Verifying visma vouchers between %s and %s... ", plan.FromDate.String(), plan.ToDate.String())
+	years, err := vi.FiscalYears()
+	handleError(err)
+	vouchers, err := db.SyncVouchers(vi, plan.FromDate, plan.ToDate, years)
+	handleError(err)
+	// Vouchers of the plan uploaded by an earlier, interrupted apply were
+	// not in visma when the plan was made.
+	journal, err := generate.OpenJournal(config.JournalFile)
+	handleError(err)
+	uploaded := []string{}
+	for _, v := range pendingVouchers {
+		if id := journal.Entry(v.Voucher).VoucherID; id != "" {
+			uploaded = append(uploaded, id)
+		}
+	}
+	err = plan.Verify(withoutVouchers(vouchers, uploaded))
+	if err == nil {
+		err = checkFiscalYears(years, pendingVouchers)
+	}
+	if err == nil {
+		err = checkVoucherAccounts(vi, years, pendingVouchers)
+	}
+	if err != nil {
+		fmt.Println()
+		handleError(err)
+	}
+	fmt.Println("DONE")
+	fmt.Println()
+
+	if config.DryRun {
+		fmt.Println("This was a dry run so no new vouchers where uploaded.")
+		return
+	}
+	uploadVouchers(config, vi, config.NewMatcher(), vouchers, pendingVouchers)
+}
+
+func withoutVouchers(vouchers []visma.Voucher, ids []string) []visma.Voucher {
+	filtered := []visma.Voucher{}
+	for _, v := range vouchers {
+		if !util.ContainsString(ids, v.ID) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+func planFileArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return "plan.json"
+}
+
+func runStatus(config Config, args []string) {
+	match := fetchAndMatch(config)
+	if match == nil {
+		return
+	}
+	fmt.Printf("Reports between %s and %s:\n", match.fromDate.String(), match.toDate.String())
+	for _, r := range match.reports {
+		status := "imported"
+		for _, u := range match.unmatchedReports {
+			if u.Date.Equal(r.Date) && u.UserID == r.UserID {
+				status = "PENDING"
+			}
+		}
+		fmt.Printf("  %s\t%-12s\t%s\t%s\n", r.Date.String(), r.Username, r.Sum().String(), status)
+	}
+	if len(match.unmatchedPayouts) > 0 {
+		fmt.Println("Pending payouts:")
+		for _, p := range match.unmatchedPayouts {
+			fmt.Printf("  %s\t%s\tfees %s\n", p.Date.String(), p.Amount.String(), p.Fees.String())
+		}
+	}
+	if len(match.unmatchedVouchers) > 0 {
+		fmt.Println("Vouchers not belonging to any report:")
+		for _, v := range match.unmatchedVouchers {
+			fmt.Printf("  %s\t%s\n", v.VoucherDate.String(), v.VoucherText)
+		}
+	}
+	fmt.Println()
+	fmt.Printf("%d of %d reports and %d payouts are waiting to be imported\n", len(match.unmatchedReports), len(match.reports), len(match.unmatchedPayouts))
+}
+
+func runListReports(config Config, args []string) {
+	var iz *izettle.Client
+	if !config.Offline {
+		fmt.Println("Logging in:")
+		iz = loginIZettle(config)
+		fmt.Println()
+	}
+
+	reports := fetchReports(config, iz)
+	for _, r := range reports {
+		fmt.Printf("%s\t%s (%d)\t%s\t(%d refunds)\n", r.Date.String(), r.Username, r.UserID, r.Sum().String(), r.RefundCount)
+		for _, row := range r.Rows {
+			fmt.Printf("    %d\t%dx %s\t%s\t%s%% VAT\n", row.VismaAccount, row.Count, row.Name, row.Amount.String(), row.VatPercentage.String())
+		}
+		for _, p := range r.Payments {
+			fmt.Printf("    %s\t%s\n", p.Type, p.Amount.String())
+		}
+	}
+}
+
+func runListVouchers(config Config, args []string) {
+	db := openCache(config)
+	defer db.Close()
+	fromDate, toDate := config.ListRange()
+
+	var vouchers []visma.Voucher
+	var err error
+	if config.Offline {
+		fmt.Printf("Reading cached visma vouchers between %s and %s... ", fromDate.String(), toDate.String())
+		vouchers, err = db.Vouchers(fromDate, toDate, nil)
+		handleError(err)
+		fmt.Println("DONE")
+	} else {
+		fmt.Println("Logging in:")
+		vi := loginVisma(config)
+		fmt.Println()
+
+		fmt.Println("Fetching:")
+		metadata := fetchVismaMetadata(config, vi)
+		fmt.Printf("  visma vouchers between %s and %s... ", fromDate.String(), toDate.String())
+		vouchers, err = db.SyncVouchers(vi, fromDate, toDate, metadata.fiscalYears)
+		handleError(err)
+		fmt.Println("DONE")
+	}
+	fmt.Println()
+
+	matcher := config.NewMatcher()
+	for _, v := range vouchers {
+		kind := ""
+		if matcher.IsPayoutVoucher(v) {
+			kind = "payout"
+		} else if matcher.IsIZettleRelated(v) {
+			kind = "izettle"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", v.VoucherDate.String(), v.NumberAndNumberSeries, kind, v.VoucherText)
+		for _, r := range v.Rows {
+			fmt.Printf("    %d\t%s\t%s\n", r.AccountNumber, r.DebitAmount.String(), r.CreditAmount.String())
+		}
+	}
+}
+
+// runCheck lists every product and account which would stop an import
+func runCheck(config Config, args []string) {
+	requireOnline(config)
+	fmt.Println("Logging in:")
+	iz := loginIZettle(config)
+	vi := loginVisma(config)
+	fmt.Println()
+
+	db := openCache(config)
+	defer db.Close()
+	fmt.Print("Fetching izettle products... ")
+	products, err := db.SyncProducts(iz)
+	handleError(err)
+	fmt.Println("DONE")
+	resolver := config.NewAccountResolver()
+	checkAccounts(resolver, products)
+	fmt.Printf("All %d products have a visma account.\n", len(products))
+
+	fmt.Print("Fetching visma accounts of the current fiscal year... ")
+	year, err := vi.CurrentFiscalYear()
+	handleError(err)
+	accounts, err := vi.Accounts(year.ID)
+	handleError(err)
+	fmt.Println("DONE")
+
+	used := map[int]string{
+		config.Visma.LedgerAccountNumber: "LedgerAccountNumber",
+	}
+	if config.Visma.OtherIncomeAccountNumber != 0 {
+		used[config.Visma.OtherIncomeAccountNumber] = "OtherIncomeAccountNumber"
+	}
+	if config.Visma.CardFeeAccountNumber != 0 {
+		used[config.Visma.CardFeeAccountNumber] = "CardFeeAccountNumber"
+	}
+	for _, a := range config.Visma.BankAccountNumbers {
+		used[a] = "BankAccountNumbers"
+	}
+	for t, a := range config.Visma.PaymentAccountNumbers {
+		used[a] = "PaymentAccountNumbers " + t
+	}
+	for vat, a := range config.Visma.VatAccountNumbers {
+		used[a] = "VatAccountNumbers " + vat
+	}
+	for _, p := range products {
+		for _, v := range p.Variants {
+			if a := resolver.Account(p, v); a != 0 {
+				used[a] = "product " + p.Name
+			}
+		}
+	}
+	missing := 0
+	for number, usedBy := range used {
+		account := visma.FindAccount(accounts, number)
+		if account == nil {
+			fmt.Printf(" - the account %d used by %s does not exist\n", number, usedBy)
+			missing++
+		} else if !account.IsActive {
+			fmt.Printf(" - the account %d %s used by %s is not active\n", number, account.Name, usedBy)
+			missing++
+		}
+	}
+	if missing > 0 {
+		handleError(fmt.Errorf("%d accounts do not exist or are not active in the fiscal year %s - %s", missing, year.StartDate.String(), year.EndDate.String()))
+	}
+	fmt.Printf("All %d accounts exist in visma.\n", len(used))
+}
+
+func runLogin(config Config, args []string) {
+	requireOnline(config)
+	fmt.Println("Logging in:")
+	loginIZettle(config)
+	loginIZettleBrowser(config)
+	loginVisma(config)
+}
+
+// runSetSecret saves a secret in the vault, the secret is read from the
+// terminal without being echoed or from stdin if it is not a terminal.
+func runSetSecret(config Config, args []string) {
+	if len(args) != 1 {
+		handleError(fmt.Errorf("expected the name of the secret"))
+	}
+	name := args[0]
+	fields := secretFields(&config.Preferences, &config.VismaEnvironment)
+	if _, ok := fields[name]; !ok {
+		names := []string{}
+		for n := range fields {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		handleError(fmt.Errorf("unknown secret %s, valid names are: %s", name, strings.Join(names, ", ")))
+	}
+	secrets, err := vault.OpenVault(config.VaultFile, config.Box)
+	handleError(err)
+
+	var secret string
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Printf("%s: ", name)
+		data, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		handleError(err)
+		secret = string(data)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != io.EOF {
+			handleError(err)
+		}
+		secret = strings.TrimRight(line, "\r\n")
+	}
+	handleError(secrets.Set(name, secret))
+	fmt.Printf("Saved %s in %s\n", name, config.VaultFile)
+}
+
+// runFetchPDF downloads the PDFs of the reports, optionally only of the
+// users given as arguments.
+func runFetchPDF(config Config, args []string) {
+	requireOnline(config)
+	fmt.Println("Logging in:")
+	iz := loginIZettle(config)
+	fetcher := newPDFFetcher(config)
+	fmt.Println()
+
+	reports := fetchReports(config, iz)
+	fmt.Println("Downloading PDFs:")
+	for _, r := range reports {
+		if len(args) > 0 && !util.ContainsString(args, r.Username) {
+			continue
+		}
+		fmt.Printf(" * %s %s\n", r.Username, r.Date.String())
+		_, err := fetcher.fetch(r)
+		if err != nil {
+			fmt.Printf("   FAILED! %s\n", err)
+		}
+	}
+}
+
+// fetchReports fetches the iZettle reports between the dates given on the
+// command line. When offline they are read from the cache instead.
+func fetchReports(config Config, iz *izettle.Client) []izettle.Report {
+	db := openCache(config)
+	defer db.Close()
+	fromDate, toDate := config.ListRange()
+
+	var products []izettle.Product
+	var purchases *izettle.Purchases
+	var err error
+	if config.Offline {
+		fmt.Printf("Reading cached izettle purchases between %s and %s... ", fromDate.String(), toDate.String())
+		products, err = db.Products()
+		handleError(err)
+		purchases, err = db.Purchases(fromDate, toDate)
+		handleError(err)
+		fmt.Println("DONE")
+	} else {
+		fmt.Println("Fetching:")
+		fmt.Print("  izettle products... ")
+		products, err = db.SyncProducts(iz)
+		handleError(err)
+		fmt.Println("DONE")
+		fmt.Printf("  izettle purchases between %s and %s... ", fromDate.String(), toDate.String())
+		purchases, err = db.SyncPurchases(iz, fromDate, toDate)
+		handleError(err)
+		fmt.Println("DONE")
+	}
+	fmt.Println()
+	return izettle.Reports(*purchases, products, config.NewAccountResolver(), config.Location)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"izettle-daily-reports/generate"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/loopback"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/vault"
+	"izettle-daily-reports/visma"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Preferences struct {
+	DryRun        bool
+	ImportPayouts bool
+	Environment   string
+	FromDate      util.Date
+	ToDate        util.Date
+	TimeZone      string
+	CacheFile     string
+	JournalFile   string
+	// KeyFile holds the passphrase which encrypts the tokens, the browser
+	// session and the vault, it can also be given as SYNC_REPORT_PASSPHRASE
+	KeyFile string
+	// VaultFile holds the secrets which are not in the config file
+	VaultFile string
+	// TokenDir is where the tokens and the iZettle browser session are saved
+	TokenDir string
+	// LocalPDFs renders the report PDFs instead of downloading them
+	// from my.izettle.com, which needs a browser login.
+	LocalPDFs bool
+	Users     []generate.User
+	Templates TemplatePreferences
+	Visma     VismaPreferences
+	IZettle   IZettlePreferences
+}
+
+// TemplatePreferences are text/template templates for the texts of the sales
+// vouchers, see generate.TemplateData for what they can use. Empty templates
+// use the defaults in the generate package.
+type TemplatePreferences struct {
+	VoucherText     string
+	TransactionText string
+	AttachmentName  string
+}
+
+type IZettlePreferences struct {
+	Email string
+	// Password is used by the browser login, and by the deprecated password
+	// grant if there is no APIKey
+	Password     string
+	ClientID     string
+	ClientSecret string
+	// APIKey is used instead of the password to log in to the API
+	APIKey string
+	// ShowBrowser logs in with a visible browser, which is needed to
+	// complete two-factor authentication or captchas.
+	ShowBrowser bool
+	// SessionWarningDays is how long before the browser session expires
+	// to renew it, with a warning if it can not be renewed.
+	SessionWarningDays int
+}
+
+type VismaPreferences struct {
+	LedgerAccountNumber      int
+	BankAccountNumbers       []int
+	PaymentAccountNumbers    map[string]int
+	OtherIncomeAccountNumber int
+	// AccountRules book products on accounts, before the barcode is tried
+	AccountRules []izettle.AccountRule
+	// IgnoreBarcodes stops using the barcode of a product as its account
+	IgnoreBarcodes             bool
+	VatAccountNumbers          map[string]int
+	CardFeeAccountNumber       int
+	UncategorizedProjectNumber string
+	// ProjectRules book products on other projects than the uncategorized one
+	ProjectRules []generate.ProjectRule
+	// CostCenter is the name or number of the cost center holding the
+	// committees, the first cost center is used if it is empty
+	CostCenter string
+	// CostCenterRules book cash registers or categories on the other cost centers
+	CostCenterRules []generate.CostCenterRule
+	Environments    []visma.Environment
+}
+
+// Config is the preferences from the config file with the
+// command line options applied.
+type Config struct {
+	Preferences
+	VismaEnvironment visma.Environment
+	Location         *time.Location
+	Offline          bool
+	// ManualLogin logs in to visma by pasting the redirected URL, for SSH
+	ManualLogin bool
+	// Box encrypts the saved tokens, it is nil if there is no passphrase
+	Box *vault.Box
+}
+
+// readConfig reads the config file and overrides it with the options
+// given on the command line.
+func readConfig(opts options) Config {
+	fmt.Printf("Reading %s... ", opts.configFile)
+	prefData, err := ioutil.ReadFile(opts.configFile)
+	handleError(err)
+	pref := Preferences{}
+	err = json.Unmarshal(prefData, &pref)
+	handleError(err)
+
+	if opts.environment != "" {
+		pref.Environment = opts.environment
+	}
+	if opts.dryRun {
+		pref.DryRun = true
+	}
+	if opts.from != "" {
+		pref.FromDate, err = util.DateFromString(opts.from)
+		handleError(err)
+	}
+	if opts.to != "" {
+		pref.ToDate, err = util.DateFromString(opts.to)
+		handleError(err)
+	}
+	if opts.showBrowser {
+		pref.IZettle.ShowBrowser = true
+	}
+	if pref.IZettle.SessionWarningDays == 0 {
+		pref.IZettle.SessionWarningDays = 7
+	}
+	if pref.CacheFile == "" {
+		pref.CacheFile = "cache.db"
+	}
+	if pref.JournalFile == "" {
+		pref.JournalFile = "journal.json"
+	}
+	if pref.TokenDir == "" {
+		pref.TokenDir = "tokens"
+	}
+	if pref.VaultFile == "" {
+		pref.VaultFile = filepath.Join(pref.TokenDir, "secrets.vault")
+	}
+
+	var environment *visma.Environment
+	for _, env := range pref.Visma.Environments {
+		if pref.Environment == env.Name {
+			environment = &env
+			break
+		}
+	}
+	if environment == nil {
+		environments := make([]string, len(pref.Visma.Environments))
+		for i, env := range pref.Visma.Environments {
+			environments[i] = env.Name
+		}
+		fmt.Println()
+		handleError(fmt.Errorf("Please provide a valid environment name. Valid names are: %s", environments))
+	}
+	box := readBox(pref)
+	readSecrets(&pref, environment, box)
+	timeZone, err := time.LoadLocation(pref.TimeZone)
+	handleError(err)
+	fmt.Println("DONE")
+	fmt.Println()
+	return Config{
+		Preferences:      pref,
+		VismaEnvironment: *environment,
+		Location:         timeZone,
+		Offline:          opts.offline,
+		ManualLogin:      opts.manualLogin,
+		Box:              box,
+	}
+}
+
+// tokenStore returns the store of a login token, encrypted if there is a passphrase
+func (c *Config) tokenStore(name string) loopback.TokenStore {
+	return loopback.NewStore(c.TokenDir, name, c.Box)
+}
+
+func (c *Config) izettleSessionFile() string {
+	return filepath.Join(c.TokenDir, "_izsessionat.token")
+}
+
+// readBox returns the box which encrypts the saved tokens, or nil if
+// neither a passphrase nor a key file is given.
+func readBox(pref Preferences) *vault.Box {
+	if passphrase := os.Getenv("SYNC_REPORT_PASSPHRASE"); passphrase != "" {
+		return vault.NewBox(passphrase)
+	}
+	if pref.KeyFile == "" {
+		return nil
+	}
+	box, err := vault.ReadKeyFile(pref.KeyFile)
+	handleError(err)
+	return box
+}
+
+// secretFields are the config values which can be given as secrets, by the
+// name of their environment variable.
+func secretFields(pref *Preferences, environment *visma.Environment) map[string]*string {
+	return map[string]*string{
+		"IZETTLE_EMAIL":         &pref.IZettle.Email,
+		"IZETTLE_PASSWORD":      &pref.IZettle.Password,
+		"IZETTLE_CLIENT_ID":     &pref.IZettle.ClientID,
+		"IZETTLE_CLIENT_SECRET": &pref.IZettle.ClientSecret,
+		"IZETTLE_API_KEY":       &pref.IZettle.APIKey,
+		"VISMA_CLIENT_ID":       &environment.ClientID,
+		"VISMA_CLIENT_SECRET":   &environment.ClientSecret,
+	}
+}
+
+// readSecrets fills in the secrets which are not in the config file from
+// environment variables, or from the vault if there is a passphrase.
+func readSecrets(pref *Preferences, environment *visma.Environment, box *vault.Box) {
+	var secrets *vault.Vault
+	if box != nil {
+		var err error
+		secrets, err = vault.OpenVault(pref.VaultFile, box)
+		handleError(err)
+	}
+	for name, field := range secretFields(pref, environment) {
+		if *field != "" {
+			continue
+		}
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		} else if secrets != nil {
+			*field, _ = secrets.Get(name)
+		}
+	}
+}
+
+// DateRange returns the dates to import, limited to the given fiscal years.
+// It returns false if there is nothing to import.
+func (c *Config) DateRange(years []visma.FiscalYear) (util.Date, util.Date, bool) {
+	if len(years) == 0 {
+		fmt.Println("\nThere are no fiscal years in visma.\nABORTING!")
+		return util.Date{}, util.Date{}, false
+	}
+	// We only import reports created more than 2 days ago, this is to make sure that we do not
+	// import a half finished report.
+	toDate := util.DateFromTime(time.Now().AddDate(0, 0, -2))
+	if !c.ToDate.Time().IsZero() {
+		if c.ToDate.After(toDate) {
+			fmt.Println("\n * To date is less than 2 days ago and is therefor ignored.")
+		} else {
+			toDate = c.ToDate
+		}
+	}
+	firstDate := years[0].StartDate
+	for _, year := range years {
+		if year.StartDate.Before(firstDate) {
+			firstDate = year.StartDate
+		}
+	}
+	var fromDate util.Date
+	if c.FromDate.Time().IsZero() {
+		// Only the current fiscal year is imported by default, earlier years
+		// have to be asked for with --from.
+		year, err := visma.FindFiscalYear(years, toDate)
+		if err != nil {
+			fmt.Printf("\nThere is no fiscal year in visma for %s.\nABORTING!\n", toDate.String())
+			return util.Date{}, toDate, false
+		}
+		if year.IsLockedForAccounting {
+			fmt.Printf("\nThe fiscal year %s - %s is locked, use --from and --to to import an open year.\nABORTING!\n",
+				year.StartDate.String(), year.EndDate.String())
+			return year.StartDate, toDate, false
+		}
+		fromDate = year.StartDate
+	} else if c.FromDate.Before(firstDate) {
+		fmt.Println("\n * From date was before the start of the first fiscal year and is therefor ignored.")
+		fromDate = firstDate
+	} else {
+		fromDate = c.FromDate
+	}
+	if fromDate.After(toDate) {
+		fmt.Println("\nThe from date is after the to date. This will not result in any imports.\nABORTING!")
+		return fromDate, toDate, false
+	}
+	return fromDate, toDate, true
+}
+
+// ListRange returns the dates to list, unlike DateRange it does not care
+// about fiscal years or half finished reports.
+func (c *Config) ListRange() (util.Date, util.Date) {
+	toDate := util.DateFromTime(time.Now())
+	if !c.ToDate.Time().IsZero() {
+		toDate = c.ToDate
+	}
+	fromDate := c.FromDate
+	if fromDate.Time().IsZero() {
+		fromDate = util.DateFromTime(toDate.Time().AddDate(0, 0, -7))
+	}
+	return fromDate, toDate
+}
+
+func (c *Config) NewAccountResolver() izettle.AccountResolver {
+	accounts, err := izettle.NewAccountResolver(c.Visma.AccountRules, !c.Visma.IgnoreBarcodes, c.Visma.OtherIncomeAccountNumber)
+	handleError(err)
+	return accounts
+}
+
+func (c *Config) NewMatcher() generate.Matcher {
+	return generate.NewMatcher(c.Visma.LedgerAccountNumber, c.Visma.BankAccountNumbers, c.Visma.CardFeeAccountNumber, c.Visma.PaymentAccountNumbers, c.Users)
+}
+
+func (c *Config) NewGenerator(matcher generate.Matcher) generate.Generator {
+	templates, err := generate.ParseTemplates(c.Templates.VoucherText, c.Templates.TransactionText, c.Templates.AttachmentName)
+	handleError(err)
+	return generate.NewGenerator(matcher, c.Visma.VatAccountNumbers, c.Visma.CardFeeAccountNumber, templates)
+}
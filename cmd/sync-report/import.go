@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"izettle-daily-reports/generate"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/pdf"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"strings"
+)
+
+// matchResult is everything fetched from iZettle and visma between two
+// dates, matched against each other.
+type matchResult struct {
+	vi                *visma.Client
+	metadata          vismaMetadata
+	matcher           generate.Matcher
+	fromDate          util.Date
+	toDate            util.Date
+	vouchers          []visma.Voucher
+	reports           []izettle.Report
+	unmatchedReports  []izettle.Report
+	unmatchedVouchers []visma.Voucher
+	unmatchedPayouts  []izettle.PayoutReport
+}
+
+// fetchAndMatch logs in and matches the iZettle reports with the visma
+// vouchers. It returns nil if there are no dates to import.
+func fetchAndMatch(config Config) *matchResult {
+	requireOnline(config)
+	db := openCache(config)
+	defer db.Close()
+
+	fmt.Println("Logging in:")
+	iz := loginIZettle(config)
+	vi := loginVisma(config)
+	fmt.Println()
+
+	fmt.Println("Fetching:")
+	metadata := fetchVismaMetadata(config, vi)
+	fromDate, toDate, ok := config.DateRange(metadata.fiscalYears)
+	if !ok {
+		return nil
+	}
+
+	fmt.Printf("  visma vouchers between %s and %s... ", fromDate.String(), toDate.String())
+	vouchers, err := db.SyncVouchers(vi, fromDate, toDate, metadata.fiscalYears)
+	handleError(err)
+	fmt.Println("DONE")
+
+	fmt.Print("  izettle products... ")
+	products, err := db.SyncProducts(iz)
+	handleError(err)
+	fmt.Println("DONE")
+	accounts := config.NewAccountResolver()
+	checkAccounts(accounts, products)
+	fmt.Printf("  izettle purchases between %s and %s... ", fromDate.String(), toDate.String())
+	purchases, err := db.SyncPurchases(iz, fromDate, toDate)
+	handleError(err)
+	fmt.Println("DONE")
+	var transactions []izettle.Transaction
+	if config.ImportPayouts {
+		fmt.Printf("  izettle payouts between %s and %s... ", fromDate.String(), toDate.String())
+		transactions, err = iz.PayoutTransactions(fromDate, toDate)
+		handleError(err)
+		fmt.Println("DONE")
+	}
+	fmt.Println()
+
+	fmt.Print("Matching iZettle reports with Visma vouchers... ")
+	matcher := config.NewMatcher()
+	reports := izettle.Reports(*purchases, products, accounts, config.Location)
+	unmatchedVouchers, err := matcher.GetUnmatchedVouchers(reports, vouchers, metadata.costCenterItems())
+	handleError(err)
+	unmatchedReports, err := matcher.GetUnmatchedReports(reports, vouchers, metadata.costCenterItems())
+	handleError(err)
+	var unmatchedPayouts []izettle.PayoutReport
+	for _, p := range matcher.GetUnmatchedPayouts(izettle.PayoutReports(transactions, config.Location), vouchers) {
+		if !p.Date.Before(fromDate) {
+			unmatchedPayouts = append(unmatchedPayouts, p)
+		}
+	}
+	fmt.Println("DONE")
+	for _, w := range matcher.UserWarnings(reports) {
+		fmt.Printf(" * %s\n", w)
+	}
+	fmt.Println()
+
+	return &matchResult{
+		vi:                vi,
+		metadata:          metadata,
+		matcher:           matcher,
+		fromDate:          fromDate,
+		toDate:            toDate,
+		vouchers:          vouchers,
+		reports:           reports,
+		unmatchedReports:  unmatchedReports,
+		unmatchedVouchers: unmatchedVouchers,
+		unmatchedPayouts:  unmatchedPayouts,
+	}
+}
+
+// checkAccounts stops before anything is imported if any product does not
+// resolve to a visma account.
+func checkAccounts(accounts izettle.AccountResolver, products []izettle.Product) {
+	unresolved := accounts.Unresolved(products)
+	if len(unresolved) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("The following products do not have a visma account, add a barcode or an account rule for them:")
+	for _, name := range unresolved {
+		fmt.Printf(" - %s\n", name)
+	}
+	handleError(fmt.Errorf("%d products do not have a visma account", len(unresolved)))
+}
+
+// prepareImport fetches everything from iZettle and visma and generates the
+// pending vouchers. It returns nil if there is nothing to import.
+func prepareImport(config Config, fetchPDFs bool) (*matchResult, []generate.PendingVoucher) {
+	match := fetchAndMatch(config)
+	if match == nil {
+		return nil, nil
+	}
+	unmatchedReports := match.unmatchedReports
+	if len(unmatchedReports) == 0 && len(match.unmatchedPayouts) == 0 {
+		fmt.Printf("All %d reports are already imported into visma. Just chilaxing for now.\n", len(match.reports))
+		return nil, nil
+	}
+
+	fmt.Println("Generating:")
+	failedReports := []izettle.Report{}
+	failures := []error{}
+	if fetchPDFs {
+		fetcher := newPDFFetcher(config)
+		fmt.Println("  PDFs...")
+		reports := []izettle.Report{}
+		for i, r := range unmatchedReports {
+			fmt.Printf(" * %d of %d (%s %s)\n", i+1, len(unmatchedReports), r.Username, r.Date.Time().Format("2006-01-02"))
+			data, err := fetcher.fetch(r)
+			if err != nil {
+				fmt.Printf("   FAILED! %s\n", err)
+				failedReports = append(failedReports, r)
+				failures = append(failures, err)
+				continue
+			}
+			r.Attachments = append(r.Attachments, data)
+			reports = append(reports, r)
+		}
+		unmatchedReports = reports
+	} else {
+		fmt.Println("  no PDFs (dry run)")
+	}
+
+	fmt.Print("  vouchers... ")
+	generator := config.NewGenerator(match.matcher)
+	pendingVouchers, ignoredReports, err := generator.GeneratePendingVouchers(unmatchedReports, match.metadata.dimensions, match.metadata.projects)
+	handleError(err)
+	payoutVouchers, err := generator.GeneratePayoutVouchers(match.unmatchedPayouts)
+	handleError(err)
+	pendingVouchers = append(pendingVouchers, payoutVouchers...)
+	err = checkFiscalYears(match.metadata.fiscalYears, pendingVouchers)
+	if err == nil {
+		err = checkVoucherAccounts(match.vi, match.metadata.fiscalYears, pendingVouchers)
+	}
+	if err != nil {
+		fmt.Println()
+		handleError(err)
+	}
+	fmt.Println("DONE")
+	fmt.Println()
+
+	if len(match.unmatchedVouchers) > 0 {
+		fmt.Printf("Found the following vouchers not belonging to any report!\n")
+		for _, v := range match.unmatchedVouchers {
+			fmt.Printf(" - %s\t%s\n", v.VoucherDate.String(), v.VoucherText)
+		}
+		fmt.Println()
+	}
+
+	if len(ignoredReports) > 0 || len(failedReports) > 0 {
+		fmt.Printf("Failed to generate vouchers for the following repports\n")
+		for _, r := range ignoredReports {
+			fmt.Printf(" - %s\t%s\n", r.Date.String(), r.Username)
+		}
+		for i, r := range failedReports {
+			fmt.Printf(" - %s\t%s\tno PDF: %s\n", r.Date.String(), r.Username, failures[i])
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Preparing to upload %d vouchers\n", len(pendingVouchers))
+	for _, v := range pendingVouchers {
+		printVoucher(match.matcher, match.metadata.costCenterItems(), v.Voucher)
+	}
+	fmt.Println()
+	fmt.Printf("Summary:\n")
+	fmt.Printf("  Project name: %s\n", match.metadata.uncategorized.Name)
+	fmt.Printf("  Number of vouchers %d\n", len(pendingVouchers))
+	fmt.Printf("  Number of payouts %d\n", len(payoutVouchers))
+	refundCount := 0
+	for _, r := range unmatchedReports {
+		refundCount += r.RefundCount
+	}
+	fmt.Printf("  Number of refunds %d\n", refundCount)
+	fmt.Printf("  Go through the pdfs folder and compare the pdfs against ")
+
+	return match, pendingVouchers
+}
+
+// checkFiscalYears makes sure that every voucher can be posted in the
+// fiscal year its date belongs to.
+func checkFiscalYears(years []visma.FiscalYear, pendingVouchers []generate.PendingVoucher) error {
+	for _, v := range pendingVouchers {
+		year, err := visma.FindFiscalYear(years, v.Voucher.VoucherDate)
+		if err != nil {
+			return fmt.Errorf("can not import the voucher %s %s: %s", v.Voucher.VoucherDate.String(), v.Voucher.VoucherText, err)
+		}
+		if year.IsLockedForAccounting {
+			return fmt.Errorf("can not import the voucher %s %s since the fiscal year %s - %s is locked, "+
+				"use --from to only import later dates", v.Voucher.VoucherDate.String(), v.Voucher.VoucherText, year.StartDate.String(), year.EndDate.String())
+		}
+	}
+	return nil
+}
+
+// checkVoucherAccounts makes sure that every account of the vouchers exists
+// and is active in the fiscal year of the voucher, so that an upload does
+// not fail half way.
+func checkVoucherAccounts(vi *visma.Client, years []visma.FiscalYear, pendingVouchers []generate.PendingVoucher) error {
+	accounts := make(map[string][]visma.Account)
+	problems := []string{}
+	for _, v := range pendingVouchers {
+		year, err := visma.FindFiscalYear(years, v.Voucher.VoucherDate)
+		if err != nil {
+			return err
+		}
+		yearAccounts, ok := accounts[year.ID]
+		if !ok {
+			yearAccounts, err = vi.Accounts(year.ID)
+			if err != nil {
+				return err
+			}
+			accounts[year.ID] = yearAccounts
+		}
+		for _, r := range v.Voucher.Rows {
+			account := visma.FindAccount(yearAccounts, r.AccountNumber)
+			if account == nil {
+				problems = append(problems, fmt.Sprintf("the account %d of %s %s does not exist", r.AccountNumber, v.Voucher.VoucherDate.String(), v.Voucher.VoucherText))
+			} else if !account.IsActive {
+				problems = append(problems, fmt.Sprintf("the account %d %s of %s %s is not active", r.AccountNumber, account.Name, v.Voucher.VoucherDate.String(), v.Voucher.VoucherText))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("can not import the vouchers:\n - %s", strings.Join(problems, "\n - "))
+	}
+	return nil
+}
+
+// pdfFetcher gets the PDFs of the reports, logging in to iZettle again if
+// the browser session expires while fetching.
+type pdfFetcher struct {
+	config    Config
+	izBrowser *izettle.BrowersClient
+	renewed   bool
+}
+
+func newPDFFetcher(config Config) *pdfFetcher {
+	f := &pdfFetcher{config: config}
+	if !config.LocalPDFs {
+		f.izBrowser = loginIZettleBrowser(config)
+	}
+	return f
+}
+
+// fetch downloads the PDF of the report, or renders it if LocalPDFs is
+// set, and saves a copy in the pdfs folder.
+func (f *pdfFetcher) fetch(report izettle.Report) ([]byte, error) {
+	var data []byte
+	if f.config.LocalPDFs {
+		data = pdf.DayReport(report)
+	} else {
+		reader, err := f.izBrowser.DayReportToPDF(report)
+		if err == izettle.ErrNotLoggedIn && !f.renewed {
+			// Only log in again once, if that did not help it will not
+			// help for the next report either.
+			f.renewed = true
+			renewed, loginErr := renewIZettleBrowser(f.config)
+			if loginErr != nil {
+				return nil, fmt.Errorf("%s and logging in again failed: %s", err, loginErr)
+			}
+			f.izBrowser = renewed
+			reader, err = f.izBrowser.DayReportToPDF(report)
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := ioutil.WriteFile(fmt.Sprintf("pdfs/%s-%s.pdf", report.Date.String(), report.Username), data, 0664)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func printVoucher(matcher generate.Matcher, costCenterItems []visma.CostCenterItem, voucher visma.Voucher) {
+	fmt.Printf("  * %s\t%s\t%s\n", voucher.VoucherDate.String(), voucher.VoucherText, voucherSum(matcher, voucher).String())
+	costCenterName := ""
+	if !matcher.IsPayoutVoucher(voucher) {
+		costCenter, err := matcher.GetVoucherCostCenter(voucher, costCenterItems)
+		handleError(err)
+		costCenterName = costCenter.ShortName
+	}
+	for _, r := range voucher.Rows {
+		fmt.Printf("          %d\t%s\t%s\t%s\n", r.AccountNumber, costCenterName, r.DebitAmount.String(), r.CreditAmount.String())
+	}
+}
+
+// uploadVouchers uploads the vouchers and their attachments. Every step is
+// recorded in the journal, so running it again after a failure only uploads
+// what is missing.
+// uploadVouchers uploads the pending vouchers, vouchers are the vouchers in
+// visma which tell if a voucher in the journal still exists.
+func uploadVouchers(config Config, vi *visma.Client, matcher generate.Matcher, vouchers []visma.Voucher, pendingVouchers []generate.PendingVoucher) {
+	journal, err := generate.OpenJournal(config.JournalFile)
+	handleError(err)
+
+	fmt.Printf("Upploading vouchers...\n")
+	failed := 0
+	for _, v := range pendingVouchers {
+		fmt.Printf(" + %s\t%s\t%s...", v.Voucher.VoucherDate.Time().Format("2006-01-02"), v.Voucher.VoucherText, voucherSum(matcher, v.Voucher).String())
+		err := uploadVoucher(vi, journal, vouchers, v)
+		if err != nil {
+			failed++
+			fmt.Printf(" FAILED! \n %s\n", err)
+		}
+	}
+	if failed > 0 {
+		handleError(fmt.Errorf("%d of %d vouchers failed to upload, run the same command again to upload the rest", failed, len(pendingVouchers)))
+	}
+}
+
+func uploadVoucher(vi *visma.Client, journal *generate.Journal, vouchers []visma.Voucher, v generate.PendingVoucher) error {
+	entry := journal.Entry(v.Voucher)
+	if entry.VoucherID != "" {
+		if containsVoucher(vouchers, entry.VoucherID) {
+			fmt.Println(" ALREADY UPLOADED")
+			return nil
+		}
+		// The voucher has been deleted in visma, so it is uploaded again
+		err := journal.Remove(v.Voucher)
+		if err != nil {
+			return err
+		}
+		entry = generate.JournalEntry{}
+	}
+
+	attachmentIDs := entry.AttachmentIDs
+	for i := len(attachmentIDs); i < len(v.Attachments); i++ {
+		attachmentData := base64.StdEncoding.EncodeToString(v.Attachments[i])
+		attachment, err := vi.NewAttachment(v.AttachmentNames[i], "application/pdf", attachmentData)
+		if err != nil {
+			return err
+		}
+		err = journal.AttachmentUploaded(v.Voucher, attachment.ID)
+		if err != nil {
+			return err
+		}
+		attachmentIDs = append(attachmentIDs, attachment.ID)
+	}
+
+	voucher := v.Voucher
+	if len(attachmentIDs) > 0 {
+		voucher.Attachments = &visma.VoucherAttachment{
+			DocumentType:  2, // Receipt
+			AttachmentIds: attachmentIDs,
+		}
+	}
+	created, err := vi.NewVoucher(voucher)
+	if err != nil {
+		return err
+	}
+	err = journal.VoucherCreated(v.Voucher, created.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Println(" DONE!")
+	return nil
+}
+
+func containsVoucher(vouchers []visma.Voucher, id string) bool {
+	for _, v := range vouchers {
+		if v.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// voucherSum returns the sum of sales for sales vouchers and the amount
+// transferred to the bank for payout vouchers.
+func voucherSum(matcher generate.Matcher, voucher visma.Voucher) util.Money {
+	if matcher.IsPayoutVoucher(voucher) {
+		return matcher.GetPayoutVoucherAmount(voucher)
+	}
+	sum, err := matcher.GetVoucherSum(voucher)
+	handleError(err)
+	return *sum
+}
@@ -1,262 +1,91 @@
 package main
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"izettle-daily-reports/generate"
-	"izettle-daily-reports/izettle"
-	"izettle-daily-reports/util"
-	"izettle-daily-reports/visma"
 	"log"
+	"os"
+	"strings"
 	"time"
 )
 
-type Preferences struct {
-	DryRun      bool
-	Environment string
-	FromDate    util.Date
-	TimeZone    string
-	Users       []generate.User
-	Visma       VismaPreferences
-	IZettle     IZettlePreferences
+// options are the command line flags shared by all commands
+type options struct {
+	configFile  string
+	environment string
+	from        string
+	to          string
+	dryRun      bool
+	offline     bool
+	showBrowser bool
+	manualLogin bool
 }
 
-type IZettlePreferences struct {
-	Email        string
-	Password     string
-	ClientID     string
-	ClientSecret string
+type command struct {
+	name        string
+	args        string
+	description string
+	run         func(config Config, args []string)
 }
 
-type VismaPreferences struct {
-	LedgerAccountNumber        int
-	BankAccountNumbers         []int
-	OtherIncomeAccountNumber   int
-	UncategorizedProjectNumber string
-	Environments               []visma.Environment
+var commands = []command{
+	{"sync", "", "Import all reports and payouts which are not in visma yet", runSync},
+	{"plan", "[file]", "Write the pending vouchers to a plan file (default plan.json)", runPlan},
+	{"apply", "[file]", "Upload the vouchers of a plan file (default plan.json)", runApply},
+	{"status", "", "Show which reports and payouts are imported into visma", runStatus},
+	{"list-reports", "", "List the iZettle reports", runListReports},
+	{"list-vouchers", "", "List the visma vouchers", runListVouchers},
+	{"check", "", "Check that every product and configured account exists in visma", runCheck},
+	{"login", "", "Log in to iZettle and visma without importing anything", runLogin},
+	{"set-secret", "<name>", "Save a password or client secret in the encrypted vault", runSetSecret},
+	{"fetch-pdf", "[user...]", "Download the iZettle report PDFs to the pdfs folder", runFetchPDF},
 }
 
 func main() {
-	fmt.Printf("izettle-report-generator run at %s\n\n", time.Now())
-
-	fmt.Print("Reading config.json... ")
-	prefData, err := ioutil.ReadFile("config.json")
-	handleError(err)
-	pref := Preferences{}
-	err = json.Unmarshal(prefData, &pref)
-	handleError(err)
-
-	var environment *visma.Environment
-	for _, env := range pref.Visma.Environments {
-		if pref.Environment == env.Name {
-			environment = &env
-			break
+	name := "sync"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
 		}
 	}
-	if environment == nil {
-		environments := make([]string, len(pref.Visma.Environments))
-		for i, env := range pref.Visma.Environments {
-			environments[i] = env.Name
-		}
-		fmt.Println()
-		handleError(fmt.Errorf("Please provide a valid environment name. Valid names are: %s", environments))
-		return
+	if cmd == nil {
+		usage()
+		os.Exit(2)
 	}
-	timeZone, err := time.LoadLocation(pref.TimeZone)
-	handleError(err)
-	fmt.Println("DONE")
-	fmt.Println()
-
-	fmt.Println("Logging in:")
-	fmt.Print("  izettle account using official API... ")
-	iz, err := izettle.Login(pref.IZettle.Email, pref.IZettle.Password, pref.IZettle.ClientID, pref.IZettle.ClientSecret)
-	handleError(err)
-	fmt.Println("DONE")
 
-	fmt.Print("  izettle account using browser cookie... ")
-	token, err := ioutil.ReadFile("tokens/_izsessionat.token")
-	izBrowser := izettle.BrowserLoginCookie(string(token))
-	if !izBrowser.IsLoggedIn() {
-		cookie := ""
-		izBrowser, cookie, err = izettle.BrowserLoginEmail(pref.IZettle.Email, pref.IZettle.Password)
-		err = ioutil.WriteFile("tokens/_izsessionat.token", []byte(cookie), 0644)
-		handleError(err)
+	opts := options{}
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	flags.StringVar(&opts.configFile, "config", "config.json", "the config file to use")
+	flags.StringVar(&opts.environment, "environment", "", "the visma environment to use, overrides the config file")
+	flags.StringVar(&opts.from, "from", "", "the first date to include, formatted as 2006-01-02")
+	flags.StringVar(&opts.to, "to", "", "the last date to include, formatted as 2006-01-02")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "do not fetch any PDFs or upload anything to visma")
+	flags.BoolVar(&opts.offline, "offline", false, "only use the local cache, supported by list-reports and list-vouchers")
+	flags.BoolVar(&opts.showBrowser, "show-browser", false, "log in to iZettle with a visible browser, overrides the config file")
+	flags.BoolVar(&opts.manualLogin, "manual-login", false, "log in to visma by pasting the URL the browser is redirected to, for use over SSH")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: sync-report %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.description)
+		flags.PrintDefaults()
 	}
-	fmt.Println("DONE")
-
-	fmt.Print("  visma account... (Check your browser, a browser window should have opened) ")
-	vi, err := visma.Login(*environment)
-	handleError(err)
-	fmt.Println("DONE")
-	fmt.Println()
+	_ = flags.Parse(args)
 
-	fmt.Println("Fetching:")
-	fmt.Print("  visma metadata... ")
-	cc, err := vi.CostCenters()
-	handleError(err)
-	projects, err := vi.Projects()
-	handleError(err)
-	currentYear, err := vi.CurrentFiscalYear()
-	handleError(err)
-	fmt.Println("DONE")
-
-	// We only import reports created more than 2 days ago, this is to make sure that we do not
-	// import a half finished report.
-	toDate := util.DateFromTime(time.Now().AddDate(0, 0, -2))
-	fromDate := currentYear.StartDate
-	if !pref.FromDate.Before(fromDate) {
-		fromDate = pref.FromDate
-	} else {
-		fmt.Println("\n * From date was before the start of this year and is therefor ignored.")
-	}
-	if fromDate.After(toDate) {
-		fmt.Println("\nThe from date is after the to date. This will not result in any imports.\nABORTING!")
-		return
-	}
-
-	// Find the uncategorized project id
-	var uncategorizedIzettlePrj visma.Project
-	for _, p := range projects {
-		if p.Number == pref.Visma.UncategorizedProjectNumber {
-			uncategorizedIzettlePrj = p
-			break
-		}
-	}
-	if uncategorizedIzettlePrj.ID == "" {
-		handleError(fmt.Errorf("unable to find poject with number: %s", pref.Visma.UncategorizedProjectNumber))
-	}
-
-	fmt.Printf("  visma vouchers between %s and %s... ", fromDate.String(), toDate.String())
-	vouchers, err := vi.Vouchers(fromDate, toDate, currentYear.ID)
-	handleError(err)
-	fmt.Println("DONE")
-
-	fmt.Print("  izettle products... ")
-	products, err := iz.Products()
-	handleError(err)
-	fmt.Println("DONE")
-	fmt.Printf("  izettle purchases between %s and %s... ", fromDate.String(), toDate.String())
-	purchases, err := iz.Purchases(fromDate, toDate)
-	handleError(err)
-	fmt.Println("DONE")
-	fmt.Println()
-
-	fmt.Print("Matching iZettle reports with Visma vouchers... ")
-	matcher := generate.NewMatcher(pref.Visma.LedgerAccountNumber, pref.Visma.BankAccountNumbers, pref.Users)
-	generator := generate.NewGenerator(matcher)
-	reports := izettle.Reports(*purchases, products, pref.Visma.OtherIncomeAccountNumber, timeZone)
-	unmatchedVouchers, err := matcher.GetUnmatchedVouchers(reports, vouchers, cc[0].Items)
-	handleError(err)
-	unmatchedReports, err := matcher.GetUnmatchedReports(reports, vouchers, cc[0].Items)
-	handleError(err)
-	fmt.Println("DONE")
-	fmt.Println()
-
-	if len(unmatchedReports) == 0 {
-		fmt.Printf("All %d reports are already imported into visma. Just chilaxing for now.\n", len(reports))
-		return
-	}
-
-	fmt.Println("Generating:")
-	if !pref.DryRun {
-		fmt.Println("  PDFs...")
-		for i, r := range unmatchedReports {
-			fmt.Printf(" * %d of %d (%s %s)\n", i+1, len(unmatchedReports), r.Username, r.Date.Time().Format("2006-01-02"))
-			pdf, err := izBrowser.DayReportToPDF(r)
-			handleError(err)
-			data, err := ioutil.ReadAll(pdf)
-			handleError(err)
-			unmatchedReports[i].Attachments = append(unmatchedReports[i].Attachments, data)
-			err = ioutil.WriteFile(fmt.Sprintf("pdfs/%s-%s.pdf", r.Date.String(), r.Username), data, 0664)
-			handleError(err)
-		}
-	} else {
-		fmt.Println("  no PDFs (dry run)")
-	}
-
-	fmt.Print("  vouchers... ")
-	pendingVouchers, ignoredReports, err := generator.GeneratePendingVouchers(unmatchedReports, cc[0].Items, uncategorizedIzettlePrj)
-	handleError(err)
-	fmt.Println("DONE")
-	fmt.Println()
-
-	if len(unmatchedVouchers) > 0 {
-		fmt.Printf("Found the following vouchers not belonging to any report!\n")
-		for _, v := range unmatchedVouchers {
-			fmt.Printf(" - %s\t%s\n", v.VoucherDate.String(), v.VoucherText)
-		}
-		fmt.Println()
-	}
-
-	if len(ignoredReports) > 0 {
-		fmt.Printf("Failed to generate vouchers for the following repports\n")
-		for _, r := range ignoredReports {
-			fmt.Printf(" - %s\t%s\n", r.Date.String(), r.Username)
-		}
-		fmt.Println()
-	}
-
-	fmt.Printf("Preparing to upload %d vouchers\n", len(pendingVouchers))
-	for _, v := range pendingVouchers {
-		sum, err := matcher.GetVoucherSum(v.Voucher)
-		handleError(err)
-		fmt.Printf("  * %s\t%s\t%s\n", v.Voucher.VoucherDate.String(), v.Voucher.VoucherText, sum.String())
-		for _, r := range v.Voucher.Rows {
-			costCenter, err := matcher.GetVoucherCostCenter(v.Voucher, cc[0].Items)
-			handleError(err)
-			fmt.Printf("          %d\t%s\t%s\t%s\n", r.AccountNumber, costCenter.ShortName, r.DebitAmount.String(), r.CreditAmount.String())
-		}
-		handleError(err)
-	}
-	fmt.Println()
-	fmt.Printf("Summary:\n")
-	fmt.Printf("  Project name: %s\n", uncategorizedIzettlePrj.Name)
-	fmt.Printf("  Number of vouchers %d\n", len(pendingVouchers))
-	fmt.Printf("  Go through the pdfs folder and compare the pdfs against ")
-
-	if pref.DryRun {
-		fmt.Println()
-		fmt.Println("This was a dry run so no new vouchers where uploaded.")
-		return
-	}
-
-	fmt.Println()
-	fmt.Println("Have you checked that all the vouchers and the summary looks correct? Type 'yes' to confirm.")
-	confirmation := ""
-	_, err = fmt.Scanln(&confirmation)
-	handleError(err)
-	if confirmation != "yes" {
-		handleError(fmt.Errorf("Aborting..."))
-	}
-
-	fmt.Printf("Upploading vouchers...\n")
-	for _, v := range pendingVouchers {
-		sum, err := matcher.GetVoucherSum(v.Voucher)
-		handleError(err)
-		fmt.Printf(" + %s\t%s\t%s...", v.Voucher.VoucherDate.Time().Format("2006-01-02"), v.Voucher.VoucherText, sum.String())
+	fmt.Printf("izettle-report-generator run at %s\n\n", time.Now())
+	config := readConfig(opts)
+	cmd.run(config, flags.Args())
+}
 
-		attachmentData := base64.StdEncoding.EncodeToString(v.Attachments[0])
-		handleError(err)
-		attachmentName := fmt.Sprintf("Autogenerated_%s_%s.pdf", v.Voucher.Rows[0].CostCenterItemID1, v.Voucher.VoucherDate.Time().Format("2006-01-02"))
-		attachment, err := vi.NewAttachment(attachmentName, "application/pdf", attachmentData)
-		if err != nil {
-			fmt.Printf(" FAILED! \n %s\n", err)
-			continue
-		}
-		v.Voucher.Attachments = &visma.VoucherAttachment{
-			DocumentType:  2, // Receipt
-			AttachmentIds: []string{attachment.ID},
-		}
-		_, err = vi.NewVoucher(v.Voucher)
-		handleError(err)
-		if err != nil {
-			fmt.Printf(" FAILED! \n %s\n", err)
-		} else {
-			fmt.Println(" DONE!")
-		}
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: sync-report <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.description)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun 'sync-report <command> -h' to see the flags of a command.\n")
 }
 
 func handleError(err error) {
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"izettle-daily-reports/cache"
+	"izettle-daily-reports/generate"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/vault"
+	"izettle-daily-reports/visma"
+	"time"
+)
+
+func loginIZettle(config Config) *izettle.Client {
+	fmt.Print("  izettle account using official API... ")
+	var iz *izettle.Client
+	var err error
+	if config.IZettle.APIKey != "" {
+		iz, err = izettle.LoginAPIKey(config.IZettle.ClientID, config.IZettle.APIKey)
+	} else {
+		iz, err = izettle.Login(config.IZettle.Email, config.IZettle.Password, config.IZettle.ClientID, config.IZettle.ClientSecret, config.tokenStore("izettle"))
+	}
+	handleError(err)
+	fmt.Println("DONE")
+	return iz
+}
+
+// loginIZettleBrowser reuses the saved browser session, and logs in again if
+// it has expired. A session about to expire is renewed if possible.
+func loginIZettleBrowser(config Config) *izettle.BrowersClient {
+	fmt.Print("  izettle account using browser cookie... ")
+	session := readIZettleSession(config)
+	izBrowser := izettle.BrowserLoginCookie(session.Cookie)
+	warning := time.Duration(config.IZettle.SessionWarningDays) * 24 * time.Hour
+	loggedIn := izBrowser.IsLoggedIn()
+	if !loggedIn || session.ExpiresWithin(warning) {
+		renewed, err := renewIZettleBrowser(config)
+		if err != nil && loggedIn {
+			fmt.Printf("\n * Unable to renew the iZettle browser session which expires %s: %s\n", session.Expires.Format("2006-01-02 15:04"), err)
+			return izBrowser
+		}
+		handleError(err)
+		izBrowser = renewed
+	}
+	fmt.Println("DONE")
+	return izBrowser
+}
+
+// renewIZettleBrowser logs in with a browser and saves the new session
+func renewIZettleBrowser(config Config) (*izettle.BrowersClient, error) {
+	izBrowser, session, err := izettle.BrowserLoginEmail(config.IZettle.Email, config.IZettle.Password, !config.IZettle.ShowBrowser)
+	if err == izettle.ErrTwoFactor || err == izettle.ErrCaptcha {
+		return nil, fmt.Errorf("%s: run 'sync-report login --show-browser' on a computer with a screen", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	err = vault.WriteFile(config.izettleSessionFile(), data, config.Box)
+	if err != nil {
+		return nil, err
+	}
+	return izBrowser, nil
+}
+
+// readIZettleSession reads the saved session, older versions saved only the cookie
+func readIZettleSession(config Config) *izettle.Session {
+	data, err := vault.ReadFile(config.izettleSessionFile(), config.Box)
+	if err != nil {
+		return &izettle.Session{}
+	}
+	session := &izettle.Session{}
+	if json.Unmarshal(data, session) != nil {
+		session = &izettle.Session{Cookie: string(data)}
+	}
+	return session
+}
+
+// openCache opens the local cache, which has to be closed when the command is done
+func openCache(config Config) *cache.Cache {
+	c, err := cache.Open(config.CacheFile)
+	handleError(err)
+	return c
+}
+
+// requireOnline stops commands which can not be run from the cache only
+func requireOnline(config Config) {
+	if config.Offline {
+		handleError(fmt.Errorf("this command can not be run with --offline"))
+	}
+}
+
+func loginVisma(config Config) *visma.Client {
+	if config.ManualLogin {
+		fmt.Print("  visma account... ")
+	} else {
+		fmt.Print("  visma account... (Check your browser, a browser window should have opened) ")
+	}
+	vi, err := visma.Login(config.VismaEnvironment, config.tokenStore("visma-"+config.VismaEnvironment.Name), config.ManualLogin)
+	handleError(err)
+	fmt.Println("DONE")
+	return vi
+}
+
+// vismaMetadata is the data from visma which is needed to match
+// and generate vouchers.
+type vismaMetadata struct {
+	dimensions    generate.Dimensions
+	fiscalYears   []visma.FiscalYear
+	uncategorized visma.Project
+	projects      generate.ProjectMapper
+}
+
+// costCenterItems returns the cost centers which the users are mapped to
+func (m vismaMetadata) costCenterItems() []visma.CostCenterItem {
+	return m.dimensions.CommitteeItems()
+}
+
+func fetchVismaMetadata(config Config, vi *visma.Client) vismaMetadata {
+	fmt.Print("  visma metadata... ")
+	cc, err := vi.CostCenters()
+	handleError(err)
+	var projects []visma.Project
+	if len(config.Visma.ProjectRules) > 0 {
+		// Every project of the rules is needed to validate them
+		projects, err = vi.Projects()
+	} else {
+		query := visma.NewQuery().Filter("Number", visma.Eq, config.Visma.UncategorizedProjectNumber)
+		projects, err = vi.FindProjects(query)
+	}
+	handleError(err)
+	fiscalYears, err := vi.FiscalYears()
+	handleError(err)
+	fmt.Println("DONE")
+
+	// Find the uncategorized project id
+	var uncategorizedIzettlePrj visma.Project
+	for _, p := range projects {
+		if p.Number == config.Visma.UncategorizedProjectNumber {
+			uncategorizedIzettlePrj = p
+			break
+		}
+	}
+	if uncategorizedIzettlePrj.ID == "" {
+		handleError(fmt.Errorf("unable to find poject with number: %s", config.Visma.UncategorizedProjectNumber))
+	}
+	mapper, err := generate.NewProjectMapper(config.Visma.ProjectRules, projects, uncategorizedIzettlePrj)
+	handleError(err)
+
+	if len(cc) == 0 {
+		handleError(fmt.Errorf("there are no cost centers in visma"))
+	}
+	committee := &cc[0]
+	if config.Visma.CostCenter != "" {
+		committee, err = visma.FindCostCenter(cc, config.Visma.CostCenter)
+		handleError(err)
+	}
+	dimensions, err := generate.NewDimensions(*committee, cc, config.Visma.CostCenterRules)
+	handleError(err)
+	return vismaMetadata{
+		dimensions:    dimensions,
+		fiscalYears:   fiscalYears,
+		uncategorized: uncategorizedIzettlePrj,
+		projects:      mapper,
+	}
+}
@@ -0,0 +1,136 @@
+package generate
+
+import (
+	"fmt"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+)
+
+// CostCenterRule books the rows matching the rule on an item of a cost
+// center other than the committee one. A rule matches a row when every
+// condition which is set matches, cash registers and categories are matched
+// by name or UUID. The cost center is given by name or number and the item
+// by name or short name.
+type CostCenterRule struct {
+	CostCenter    string
+	Item          string
+	CashRegisters []string
+	Categories    []string
+}
+
+type costCenterRule struct {
+	CostCenterRule
+	dimension int
+	itemID    string
+}
+
+// CostCenterItems are the IDs of the cost center items of dimension 1 to 3
+type CostCenterItems [3]string
+
+// apply returns the row booked on the cost center items
+func (c CostCenterItems) apply(row visma.VoucherRow) visma.VoucherRow {
+	for i, id := range c {
+		row.SetCostCenterItemID(i+1, id)
+	}
+	return row
+}
+
+// Dimensions knows which cost center holds the committees the users are
+// mapped to, and which items of the other cost centers the rows are booked on.
+type Dimensions struct {
+	committee visma.CostCenter
+	rules     []costCenterRule
+}
+
+// NewDimensions makes sure that the cost center and item of every rule
+// exists in visma. The number of a cost center is its dimension.
+func NewDimensions(committee visma.CostCenter, costCenters []visma.CostCenter, rules []CostCenterRule) (Dimensions, error) {
+	d := Dimensions{committee: committee}
+	if committee.Number < 1 || committee.Number > 3 {
+		return d, fmt.Errorf("the cost center %s has the unknown dimension %d", committee.Name, committee.Number)
+	}
+	for i, rule := range rules {
+		costCenter, err := visma.FindCostCenter(costCenters, rule.CostCenter)
+		if err != nil {
+			return d, fmt.Errorf("cost center rule %d: %s", i+1, err)
+		}
+		if costCenter.ID == committee.ID {
+			return d, fmt.Errorf("cost center rule %d: the committees are already booked on %s", i+1, committee.Name)
+		}
+		if costCenter.Number < 1 || costCenter.Number > 3 {
+			return d, fmt.Errorf("cost center rule %d: the cost center %s has the unknown dimension %d", i+1, costCenter.Name, costCenter.Number)
+		}
+		item, err := costCenter.FindItem(rule.Item)
+		if err != nil {
+			return d, fmt.Errorf("cost center rule %d: %s", i+1, err)
+		}
+		if len(rule.CashRegisters) == 0 && len(rule.Categories) == 0 {
+			return d, fmt.Errorf("cost center rule %d: the rule for %s matches everything", i+1, rule.Item)
+		}
+		d.rules = append(d.rules, costCenterRule{CostCenterRule: rule, dimension: costCenter.Number, itemID: item.ID})
+	}
+	return d, nil
+}
+
+// CommitteeItems returns the cost center items which the users are mapped to
+func (d Dimensions) CommitteeItems() []visma.CostCenterItem {
+	return d.committee.Items
+}
+
+// Committee returns the cost center items with only the committee set
+func (d Dimensions) Committee(committeeID string) CostCenterItems {
+	var items CostCenterItems
+	items[d.committee.Number-1] = committeeID
+	return items
+}
+
+// Items returns the cost center items of a row, the first matching rule
+// of each dimension is used.
+func (d Dimensions) Items(committeeID string, row izettle.ReportRow) CostCenterItems {
+	items := d.Committee(committeeID)
+	for _, rule := range d.rules {
+		if items[rule.dimension-1] == "" && rule.matches(row) {
+			items[rule.dimension-1] = rule.itemID
+		}
+	}
+	return items
+}
+
+// DimensionReport is the part of a report which is booked on the cost center items
+type DimensionReport struct {
+	Items  CostCenterItems
+	Report izettle.Report
+}
+
+// Split splits the rows of the report by cost center items, in the order
+// the items are first seen.
+func (d Dimensions) Split(committeeID string, report izettle.Report) []DimensionReport {
+	parts := []DimensionReport{}
+	for _, row := range report.Rows {
+		items := d.Items(committeeID, row)
+		i := 0
+		for i < len(parts) && parts[i].Items != items {
+			i++
+		}
+		if i == len(parts) {
+			part := report
+			part.Rows = nil
+			parts = append(parts, DimensionReport{Items: items, Report: part})
+		}
+		parts[i].Report.Rows = append(parts[i].Report.Rows, row)
+	}
+	return parts
+}
+
+func (r costCenterRule) matches(row izettle.ReportRow) bool {
+	if len(r.CashRegisters) > 0 && (row.CashRegister.UUID == "" ||
+		!util.ContainsString(r.CashRegisters, row.CashRegister.DisplayName) && !util.ContainsString(r.CashRegisters, row.CashRegister.UUID)) {
+		return false
+	}
+	if len(r.Categories) > 0 && (row.Category.UUID == "" ||
+		!util.ContainsString(r.Categories, row.Category.Name) && !util.ContainsString(r.Categories, row.Category.UUID)) {
+		return false
+	}
+	return true
+}
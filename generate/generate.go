@@ -3,29 +3,49 @@ package generate
 import (
 	"fmt"
 	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
 	"izettle-daily-reports/visma"
+
+	"github.com/shopspring/decimal"
 )
 
 type Generator struct {
-	matcher Matcher
+	matcher           Matcher
+	vatAccountNumbers map[string]int
+	feeAccountNumber  int
+	templates         Templates
 }
 
-func NewGenerator(matcher Matcher) Generator {
+// NewGenerator creates a generator which books the VAT of each sale on the
+// output VAT account given by vatAccountNumbers, which maps a VAT
+// percentage such as "25" to an account number. The card fees withheld from
+// payouts are booked on feeAccountNumber. The texts of the sales vouchers
+// are made with the templates.
+func NewGenerator(matcher Matcher, vatAccountNumbers map[string]int, feeAccountNumber int, templates Templates) Generator {
 	return Generator{
-		matcher: matcher,
+		matcher:           matcher,
+		vatAccountNumbers: vatAccountNumbers,
+		feeAccountNumber:  feeAccountNumber,
+		templates:         templates,
 	}
 }
 
 type PendingVoucher struct {
 	Voucher     visma.Voucher
 	Attachments [][]byte
+	// AttachmentNames are the file names of the attachments in visma
+	AttachmentNames []string
 }
 
-func (g *Generator) GeneratePendingVouchers(unmatchedReports []izettle.Report, costCenterItems []visma.CostCenterItem, vismaProject visma.Project) ([]PendingVoucher, []izettle.Report, error) {
+// GeneratePendingVouchers creates one voucher per report. The rows of each
+// product are booked on the project given by the project mapper and the cost
+// center items given by the dimensions, while the settlement rows are booked
+// on the uncategorized project and only the committee of the user.
+func (g *Generator) GeneratePendingVouchers(unmatchedReports []izettle.Report, dimensions Dimensions, projects ProjectMapper) ([]PendingVoucher, []izettle.Report, error) {
 	ignoredReports := []izettle.Report{}
 	pendingVouchers := []PendingVoucher{}
 	for _, report := range unmatchedReports {
-		costCenter, err := g.matcher.GetReportCostCenter(report, costCenterItems)
+		costCenter, err := g.matcher.GetReportCostCenter(report, dimensions.CommitteeItems())
 		if err != nil {
 			fmt.Printf("We did not manage to lookup the cost center for the user %s\n"+
 				"this is probably due to a name being wrong in izettle"+
@@ -34,34 +54,211 @@ func (g *Generator) GeneratePendingVouchers(unmatchedReports []izettle.Report, c
 			ignoredReports = append(ignoredReports, report)
 			continue
 		}
-		vismaAccountRows, err := report.RowsByVismaAccount()
+		data := newTemplateData(report, *costCenter)
+		sales := &salesRows{}
+		for _, projectPart := range projects.Split(report) {
+			for _, part := range dimensions.Split(costCenter.ID, projectPart.Report) {
+				err := g.addSalesRows(sales, part.Report, data, part.Items, projectPart.Project.ID)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		rows := g.settlementRows(report, dimensions.Committee(costCenter.ID), projects.Uncategorized().ID)
+		rows = append(rows, sales.sales...)
+		rows = append(rows, sales.vat...)
+		rows = append(rows, sales.refunds...)
+		rows = append(rows, sales.refundVat...)
+		voucherText, err := g.templates.VoucherText(data)
 		if err != nil {
 			return nil, nil, err
 		}
-		var rows []visma.VoucherRow
-		rows = append(rows, visma.VoucherRow{
-			AccountNumber:     g.matcher.ledgerAccountNumber,
-			DebitAmount:       report.Sum(),
-			CostCenterItemID1: costCenter.ID,
-			ProjectID:         vismaProject.ID,
-		})
-		for _, s := range vismaAccountRows {
-			rows = append(rows, visma.VoucherRow{
-				AccountNumber:     s.VismaAccount,
-				CreditAmount:      s.Amount,
-				CostCenterItemID1: costCenter.ID,
-				ProjectID:         vismaProject.ID,
-			})
+		attachmentNames := []string{}
+		for i := range report.Attachments {
+			name, err := g.templates.AttachmentName(data, i+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			attachmentNames = append(attachmentNames, name)
 		}
 		voucher := visma.Voucher{
 			VoucherDate: report.Date,
-			VoucherText: "Uncategorized iZettle Import",
+			VoucherText: voucherText,
 			Rows:        rows,
 		}
 		pendingVouchers = append(pendingVouchers, PendingVoucher{
-			Voucher:     voucher,
-			Attachments: report.Attachments,
+			Voucher:         voucher,
+			Attachments:     report.Attachments,
+			AttachmentNames: attachmentNames,
 		})
 	}
 	return pendingVouchers, ignoredReports, nil
 }
+
+// salesRows are the rows of a sales voucher apart from the settlement rows
+type salesRows struct {
+	sales     []visma.VoucherRow
+	vat       []visma.VoucherRow
+	refunds   []visma.VoucherRow
+	refundVat []visma.VoucherRow
+}
+
+// addSalesRows books the rows of the report on the cost center items and project
+func (g *Generator) addSalesRows(rows *salesRows, report izettle.Report, data TemplateData, items CostCenterItems, projectID string) error {
+	vismaAccountRows, err := report.RowsByVismaAccount()
+	if err != nil {
+		return err
+	}
+	for _, s := range vismaAccountRows {
+		var vatAccount int
+		if !s.VatAmount.IsZero() {
+			vatAccount, err = g.vatAccountNumber(s.VatPercentage)
+			if err != nil {
+				return err
+			}
+		}
+		// Rows on the same account are only merged if they have the same text
+		text, err := g.templates.TransactionText(data, s)
+		if err != nil {
+			return err
+		}
+		if s.Refund {
+			// Refunds are booked on their own debit rows instead of being
+			// netted against the sales, so that they can be traced.
+			rows.refunds = addRow(rows.refunds, items.apply(visma.VoucherRow{
+				AccountNumber:   s.VismaAccount,
+				DebitAmount:     util.Money{Decimal: s.NetAmount().Neg()},
+				TransactionText: text,
+				ProjectID:       projectID,
+			}))
+			if vatAccount != 0 {
+				rows.refundVat = addRow(rows.refundVat, items.apply(visma.VoucherRow{
+					AccountNumber:   vatAccount,
+					DebitAmount:     util.Money{Decimal: s.VatAmount.Neg()},
+					TransactionText: "Refunds",
+					ProjectID:       projectID,
+				}))
+			}
+			continue
+		}
+		rows.sales = addRow(rows.sales, items.apply(visma.VoucherRow{
+			AccountNumber:   s.VismaAccount,
+			CreditAmount:    s.NetAmount(),
+			TransactionText: text,
+			ProjectID:       projectID,
+		}))
+		if vatAccount != 0 {
+			rows.vat = addRow(rows.vat, items.apply(visma.VoucherRow{
+				AccountNumber: vatAccount,
+				CreditAmount:  s.VatAmount,
+				ProjectID:     projectID,
+			}))
+		}
+	}
+	return nil
+}
+
+// GeneratePayoutVouchers creates one voucher per payout which clears the
+// ledger account, the payout is debited to the first bank account and the
+// withheld card fees to the fee account.
+func (g *Generator) GeneratePayoutVouchers(unmatchedPayouts []izettle.PayoutReport) ([]PendingVoucher, error) {
+	pendingVouchers := []PendingVoucher{}
+	if len(unmatchedPayouts) == 0 {
+		return pendingVouchers, nil
+	}
+	if len(g.matcher.bankAccountNumbers) == 0 {
+		return nil, fmt.Errorf("a bank account is required to book payouts")
+	}
+	for _, payout := range unmatchedPayouts {
+		rows := []visma.VoucherRow{{
+			AccountNumber: g.matcher.bankAccountNumbers[0],
+			DebitAmount:   payout.Amount,
+		}}
+		if !payout.Fees.IsZero() {
+			if g.feeAccountNumber == 0 {
+				return nil, fmt.Errorf("a fee account is required to book the fees of the payout %s", payout.Date.String())
+			}
+			rows = append(rows, debitRow(g.feeAccountNumber, payout.Fees.Decimal, CostCenterItems{}, ""))
+		}
+		rows = append(rows, visma.VoucherRow{
+			AccountNumber: g.matcher.ledgerAccountNumber,
+			CreditAmount:  payout.Sum(),
+		})
+		voucher := visma.Voucher{
+			VoucherDate: payout.Date,
+			VoucherText: "iZettle Payout",
+			Rows:        rows,
+		}
+		pendingVouchers = append(pendingVouchers, PendingVoucher{
+			Voucher: voucher,
+		})
+	}
+	return pendingVouchers, nil
+}
+
+// settlementRows debits the sum of the report to the account of each
+// payment type. Whatever is not covered by a payment type with its own
+// account is debited to the ledger account, so the voucher always balances.
+func (g *Generator) settlementRows(report izettle.Report, items CostCenterItems, projectID string) []visma.VoucherRow {
+	var rows []visma.VoucherRow
+	remaining := report.Sum().Decimal
+	for _, p := range report.Payments {
+		account := g.matcher.PaymentAccountNumber(p.Type)
+		if account == g.matcher.ledgerAccountNumber || p.Amount.IsZero() {
+			continue
+		}
+		remaining = remaining.Sub(p.Amount.Decimal)
+		rows = addRow(rows, debitRow(account, p.Amount.Decimal, items, projectID))
+	}
+	if !remaining.IsZero() || len(rows) == 0 {
+		ledgerRow := debitRow(g.matcher.ledgerAccountNumber, remaining, items, projectID)
+		rows = append([]visma.VoucherRow{ledgerRow}, rows...)
+	}
+	return rows
+}
+
+// debitRow debits the amount to the account, negative amounts are credited
+func debitRow(account int, amount decimal.Decimal, items CostCenterItems, projectID string) visma.VoucherRow {
+	row := items.apply(visma.VoucherRow{
+		AccountNumber: account,
+		ProjectID:     projectID,
+	})
+	if amount.IsNegative() {
+		// E.g. when more was refunded than sold during the day
+		row.CreditAmount = util.Money{Decimal: amount.Neg()}
+	} else {
+		row.DebitAmount = util.Money{Decimal: amount}
+	}
+	return row
+}
+
+func (g *Generator) vatAccountNumber(percentage util.Money) (int, error) {
+	for p, account := range g.vatAccountNumbers {
+		d, err := decimal.NewFromString(p)
+		if err != nil {
+			return 0, fmt.Errorf("invalid VAT percentage in config: %s", p)
+		}
+		if d.Equal(percentage.Decimal) {
+			return account, nil
+		}
+	}
+	return 0, fmt.Errorf("no output VAT account configured for %s%% VAT", percentage.String())
+}
+
+// addRow adds the amounts to an existing row with the same account, project,
+// cost center items and text or appends the row if there is none. Rows with
+// different texts are kept apart so that no text is lost.
+func addRow(rows []visma.VoucherRow, row visma.VoucherRow) []visma.VoucherRow {
+	for i := range rows {
+		if rows[i].AccountNumber == row.AccountNumber && rows[i].ProjectID == row.ProjectID &&
+			rows[i].TransactionText == row.TransactionText &&
+			rows[i].CostCenterItemID1 == row.CostCenterItemID1 &&
+			rows[i].CostCenterItemID2 == row.CostCenterItemID2 &&
+			rows[i].CostCenterItemID3 == row.CostCenterItemID3 {
+			rows[i].DebitAmount = util.Money{Decimal: rows[i].DebitAmount.Add(row.DebitAmount.Decimal)}
+			rows[i].CreditAmount = util.Money{Decimal: rows[i].CreditAmount.Add(row.CreditAmount.Decimal)}
+			return rows
+		}
+	}
+	return append(rows, row)
+}
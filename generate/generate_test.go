@@ -0,0 +1,587 @@
+package generate
+
+import (
+	"fmt"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	foodAccount    = 3020
+	foodVatAccount = 2621
+	bookVatAccount = 2631
+)
+
+var testDate = util.DateFromStringOrPanic("2020-10-13")
+
+var committee = visma.CostCenter{ID: "committees", Name: "Kommitté", Number: 1, Items: []visma.CostCenterItem{
+	{ID: "zik", Name: "ZIK", ShortName: "ZIK"},
+}}
+
+var uncategorized = visma.Project{ID: "p1", Number: "1", Name: "Uncategorized iZettle Import"}
+
+func testGenerator(t *testing.T) Generator {
+	templates, err := ParseTemplates("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewGenerator(testMatcher(), map[string]int{"25": vatAccount, "12": foodVatAccount, "6": bookVatAccount}, feeAccount, templates)
+}
+
+func reportRow(name string, account int, vat string, amount string) izettle.ReportRow {
+	return izettle.ReportRow{Name: name, Count: 1, Amount: money(amount), VatPercentage: money(vat), VismaAccount: account}
+}
+
+func refundRow(name string, account int, vat string, amount string) izettle.ReportRow {
+	row := reportRow(name, account, vat, amount)
+	row.Refund = true
+	return row
+}
+
+func payment(paymentType string, amount string) izettle.PaymentRow {
+	return izettle.PaymentRow{Type: paymentType, Amount: money(amount)}
+}
+
+// rowString formats the row so that the rows can be compared in tests
+func rowString(r visma.VoucherRow) string {
+	var s string
+	switch {
+	case !r.DebitAmount.IsZero() && !r.CreditAmount.IsZero():
+		s = fmt.Sprintf("%d debit %s credit %s", r.AccountNumber, r.DebitAmount.StringFixed(2), r.CreditAmount.StringFixed(2))
+	case !r.CreditAmount.IsZero():
+		s = fmt.Sprintf("%d credit %s", r.AccountNumber, r.CreditAmount.StringFixed(2))
+	default:
+		s = fmt.Sprintf("%d debit %s", r.AccountNumber, r.DebitAmount.StringFixed(2))
+	}
+	if r.TransactionText != "" {
+		s += " " + strconv.Quote(r.TransactionText)
+	}
+	for dimension := 1; dimension <= 3; dimension++ {
+		if id := r.CostCenterItemID(dimension); id != "" {
+			s += fmt.Sprintf(" cc%d=%s", dimension, id)
+		}
+	}
+	if r.ProjectID != "" {
+		s += " project=" + r.ProjectID
+	}
+	return s
+}
+
+// checkVoucher makes sure that the voucher has the rows and balances
+func checkVoucher(t *testing.T, voucher visma.Voucher, want []string) {
+	t.Helper()
+	got := []string{}
+	balance := decimal.Zero
+	for _, r := range voucher.Rows {
+		got = append(got, rowString(r))
+		balance = balance.Add(r.DebitAmount.Decimal).Sub(r.CreditAmount.Decimal)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected the rows\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	if !balance.IsZero() {
+		t.Errorf("expected the voucher to balance, the difference is %s", balance)
+	}
+}
+
+type voucherTest struct {
+	name     string
+	rows     []izettle.ReportRow
+	payments []izettle.PaymentRow
+	want     []string
+}
+
+func runVoucherTests(t *testing.T, tests []voucherTest, dimensions Dimensions, projects ProjectMapper) {
+	t.Helper()
+	g := testGenerator(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := izettle.Report{Date: testDate, Username: "ZIK", Rows: test.rows, Payments: test.payments}
+			vouchers, ignored, err := g.GeneratePendingVouchers([]izettle.Report{report}, dimensions, projects)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ignored) != 0 || len(vouchers) != 1 {
+				t.Fatalf("expected one voucher, got %d and %d ignored reports", len(vouchers), len(ignored))
+			}
+			checkVoucher(t, vouchers[0].Voucher, test.want)
+		})
+	}
+}
+
+func testDimensions(t *testing.T, costCenters []visma.CostCenter, rules []CostCenterRule) Dimensions {
+	dimensions, err := NewDimensions(committee, append([]visma.CostCenter{committee}, costCenters...), rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dimensions
+}
+
+func testProjects(t *testing.T, projects []visma.Project, rules []ProjectRule) ProjectMapper {
+	mapper, err := NewProjectMapper(rules, projects, uncategorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mapper
+}
+
+func TestVatRows(t *testing.T) {
+	runVoucherTests(t, []voucherTest{
+		{
+			name: "one VAT row per rate",
+			rows: []izettle.ReportRow{
+				reportRow("Beer", salesAccount, "25", "125"),
+				reportRow("Food", foodAccount, "12", "112"),
+				reportRow("Snacks", salesAccount, "25", "50"),
+			},
+			want: []string{
+				"1690 debit 287.00 cc1=zik project=p1",
+				"3010 credit 140.00 cc1=zik project=p1",
+				"3020 credit 100.00 cc1=zik project=p1",
+				"2611 credit 35.00 cc1=zik project=p1",
+				"2621 credit 12.00 cc1=zik project=p1",
+			},
+		},
+		{
+			name: "an account with two VAT rates",
+			rows: []izettle.ReportRow{
+				reportRow("Beer", salesAccount, "25", "125"),
+				reportRow("Book", salesAccount, "6", "106"),
+			},
+			want: []string{
+				"1690 debit 231.00 cc1=zik project=p1",
+				"3010 credit 200.00 cc1=zik project=p1",
+				"2631 credit 6.00 cc1=zik project=p1",
+				"2611 credit 25.00 cc1=zik project=p1",
+			},
+		},
+		{
+			name: "no VAT",
+			rows: []izettle.ReportRow{reportRow("Deposit", 2890, "0", "10")},
+			want: []string{
+				"1690 debit 10.00 cc1=zik project=p1",
+				"2890 credit 10.00 cc1=zik project=p1",
+			},
+		},
+	}, testDimensions(t, nil, nil), testProjects(t, nil, nil))
+}
+
+func TestMissingVatAccount(t *testing.T) {
+	g := testGenerator(t)
+	report := izettle.Report{Date: testDate, Username: "ZIK", Rows: []izettle.ReportRow{reportRow("Wine", salesAccount, "30", "130")}}
+	_, _, err := g.GeneratePendingVouchers([]izettle.Report{report}, testDimensions(t, nil, nil), testProjects(t, nil, nil))
+	if err == nil {
+		t.Error("expected a VAT rate without an account to fail")
+	}
+}
+
+func TestRefundRows(t *testing.T) {
+	runVoucherTests(t, []voucherTest{
+		{
+			name: "refunds are not netted against the sales",
+			rows: []izettle.ReportRow{
+				reportRow("Beer", salesAccount, "25", "125"),
+				refundRow("Beer", salesAccount, "25", "-25"),
+			},
+			want: []string{
+				"1690 debit 100.00 cc1=zik project=p1",
+				"3010 credit 100.00 cc1=zik project=p1",
+				"2611 credit 25.00 cc1=zik project=p1",
+				`3010 debit 20.00 "Refunds" cc1=zik project=p1`,
+				`2611 debit 5.00 "Refunds" cc1=zik project=p1`,
+			},
+		},
+		{
+			name: "more refunds than sales",
+			rows: []izettle.ReportRow{
+				reportRow("Food", foodAccount, "12", "22.4"),
+				refundRow("Beer", salesAccount, "25", "-50"),
+			},
+			want: []string{
+				"1690 credit 27.60 cc1=zik project=p1",
+				"3020 credit 20.00 cc1=zik project=p1",
+				"2621 credit 2.40 cc1=zik project=p1",
+				`3010 debit 40.00 "Refunds" cc1=zik project=p1`,
+				`2611 debit 10.00 "Refunds" cc1=zik project=p1`,
+			},
+		},
+	}, testDimensions(t, nil, nil), testProjects(t, nil, nil))
+}
+
+func TestSettlementRows(t *testing.T) {
+	runVoucherTests(t, []voucherTest{
+		{
+			name: "payment types with and without their own account",
+			rows: []izettle.ReportRow{reportRow("Beer", salesAccount, "25", "225")},
+			payments: []izettle.PaymentRow{
+				payment("IZETTLE_CARD", "75"),
+				payment("IZETTLE_CASH", "100"),
+				payment("SWISH", "50"),
+			},
+			want: []string{
+				"1690 debit 75.00 cc1=zik project=p1",
+				"1910 debit 100.00 cc1=zik project=p1",
+				"1931 debit 50.00 cc1=zik project=p1",
+				"3010 credit 180.00 cc1=zik project=p1",
+				"2611 credit 45.00 cc1=zik project=p1",
+			},
+		},
+		{
+			name:     "everything paid on an account of its own",
+			rows:     []izettle.ReportRow{reportRow("Beer", salesAccount, "25", "125")},
+			payments: []izettle.PaymentRow{payment("IZETTLE_CASH", "125")},
+			want: []string{
+				"1910 debit 125.00 cc1=zik project=p1",
+				"3010 credit 100.00 cc1=zik project=p1",
+				"2611 credit 25.00 cc1=zik project=p1",
+			},
+		},
+		{
+			name: "refunded cash",
+			rows: []izettle.ReportRow{
+				reportRow("Beer", salesAccount, "25", "100"),
+				refundRow("Beer", salesAccount, "25", "-30"),
+			},
+			payments: []izettle.PaymentRow{
+				payment("IZETTLE_CARD", "100"),
+				payment("IZETTLE_CASH", "-30"),
+			},
+			want: []string{
+				"1690 debit 100.00 cc1=zik project=p1",
+				"1910 credit 30.00 cc1=zik project=p1",
+				"3010 credit 80.00 cc1=zik project=p1",
+				"2611 credit 20.00 cc1=zik project=p1",
+				`3010 debit 24.00 "Refunds" cc1=zik project=p1`,
+				`2611 debit 6.00 "Refunds" cc1=zik project=p1`,
+			},
+		},
+	}, testDimensions(t, nil, nil), testProjects(t, nil, nil))
+}
+
+func TestAddRow(t *testing.T) {
+	row := func(account int, amount string, text string, costCenter string) visma.VoucherRow {
+		return visma.VoucherRow{AccountNumber: account, CreditAmount: money(amount), TransactionText: text, CostCenterItemID1: costCenter, ProjectID: "p1"}
+	}
+	tests := []struct {
+		name string
+		rows []visma.VoucherRow
+		want []string
+	}{
+		{
+			name: "the same account is merged",
+			rows: []visma.VoucherRow{row(salesAccount, "100", "", "zik"), row(foodAccount, "50", "", "zik"), row(salesAccount, "20", "", "zik")},
+			want: []string{"3010 credit 120.00 cc1=zik project=p1", "3020 credit 50.00 cc1=zik project=p1"},
+		},
+		{
+			name: "different texts are kept apart",
+			rows: []visma.VoucherRow{row(salesAccount, "100", "Beer", "zik"), row(salesAccount, "20", "Wine", "zik")},
+			want: []string{`3010 credit 100.00 "Beer" cc1=zik project=p1`, `3010 credit 20.00 "Wine" cc1=zik project=p1`},
+		},
+		{
+			name: "different cost centers are kept apart",
+			rows: []visma.VoucherRow{row(salesAccount, "100", "", "zik"), row(salesAccount, "20", "", "zexet")},
+			want: []string{"3010 credit 100.00 cc1=zik project=p1", "3010 credit 20.00 cc1=zexet project=p1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var rows []visma.VoucherRow
+			for _, r := range test.rows {
+				rows = addRow(rows, r)
+			}
+			got := []string{}
+			for _, r := range rows {
+				got = append(got, rowString(r))
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func TestGeneratePayoutVouchers(t *testing.T) {
+	tests := []struct {
+		name   string
+		payout izettle.PayoutReport
+		want   []string
+	}{
+		{
+			name:   "payout with fees",
+			payout: izettle.PayoutReport{Date: testDate, Amount: money("98"), Fees: money("2")},
+			want:   []string{"1930 debit 98.00", "6570 debit 2.00", "1690 credit 100.00"},
+		},
+		{
+			name:   "payout without fees",
+			payout: izettle.PayoutReport{Date: testDate, Amount: money("100")},
+			want:   []string{"1930 debit 100.00", "1690 credit 100.00"},
+		},
+		{
+			name:   "refunded fees",
+			payout: izettle.PayoutReport{Date: testDate, Amount: money("100"), Fees: money("-0.5")},
+			want:   []string{"1930 debit 100.00", "6570 credit 0.50", "1690 credit 99.50"},
+		},
+	}
+	g := testGenerator(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vouchers, err := g.GeneratePayoutVouchers([]izettle.PayoutReport{test.payout})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(vouchers) != 1 {
+				t.Fatalf("expected one voucher, got %d", len(vouchers))
+			}
+			checkVoucher(t, vouchers[0].Voucher, test.want)
+			if !g.matcher.IsPayoutVoucher(vouchers[0].Voucher) {
+				t.Error("expected the voucher to be a payout voucher")
+			}
+		})
+	}
+}
+
+func TestGeneratePayoutVouchersWithoutFeeAccount(t *testing.T) {
+	g := testGenerator(t)
+	g.feeAccountNumber = 0
+	_, err := g.GeneratePayoutVouchers([]izettle.PayoutReport{{Date: testDate, Amount: money("98"), Fees: money("2")}})
+	if err == nil {
+		t.Error("expected fees without a fee account to fail")
+	}
+}
+
+func categoryRow(name string, account int, vat string, amount string, category string) izettle.ReportRow {
+	row := reportRow(name, account, vat, amount)
+	row.ProductUUID = strings.ToLower(name) + "-uuid"
+	row.Category = izettle.Category{UUID: strings.ToLower(category) + "-uuid", Name: category}
+	return row
+}
+
+func TestProjectSplit(t *testing.T) {
+	pub := visma.Project{ID: "p12", Number: "12", Name: "Pub"}
+	cider := visma.Project{ID: "p13", Number: "13", Name: "Cider"}
+	projects := testProjects(t, []visma.Project{uncategorized, pub, cider}, []ProjectRule{
+		{ProjectNumber: "12", Categories: []string{"Pub"}},
+		{ProjectNumber: "13", ProductUUIDs: []string{"cider-uuid"}, FromDate: util.DateFromStringOrPanic("2020-11-01")},
+	})
+	runVoucherTests(t, []voucherTest{
+		{
+			name: "rows split by project",
+			rows: []izettle.ReportRow{
+				categoryRow("Beer", salesAccount, "25", "125", "Pub"),
+				reportRow("Food", foodAccount, "12", "112"),
+			},
+			payments: []izettle.PaymentRow{payment("IZETTLE_CARD", "237")},
+			want: []string{
+				"1690 debit 237.00 cc1=zik project=p1",
+				"3020 credit 100.00 cc1=zik project=p1",
+				"3010 credit 100.00 cc1=zik project=p12",
+				"2621 credit 12.00 cc1=zik project=p1",
+				"2611 credit 25.00 cc1=zik project=p12",
+			},
+		},
+		{
+			name: "same account on two projects",
+			rows: []izettle.ReportRow{
+				categoryRow("Beer", salesAccount, "25", "125", "Pub"),
+				categoryRow("Lemonade", salesAccount, "25", "50", "Cafe"),
+			},
+			payments: []izettle.PaymentRow{payment("IZETTLE_CARD", "175")},
+			want: []string{
+				"1690 debit 175.00 cc1=zik project=p1",
+				"3010 credit 40.00 cc1=zik project=p1",
+				"3010 credit 100.00 cc1=zik project=p12",
+				"2611 credit 10.00 cc1=zik project=p1",
+				"2611 credit 25.00 cc1=zik project=p12",
+			},
+		},
+		{
+			name: "refunds on the project",
+			rows: []izettle.ReportRow{
+				categoryRow("Beer", salesAccount, "25", "125", "Pub"),
+				refundRow("Food", foodAccount, "12", "-56"),
+				func() izettle.ReportRow {
+					row := categoryRow("Beer", salesAccount, "25", "-25", "Pub")
+					row.Refund = true
+					return row
+				}(),
+			},
+			payments: []izettle.PaymentRow{payment("IZETTLE_CARD", "44")},
+			want: []string{
+				"1690 debit 44.00 cc1=zik project=p1",
+				"3010 credit 100.00 cc1=zik project=p12",
+				"2611 credit 25.00 cc1=zik project=p12",
+				`3020 debit 50.00 "Refunds" cc1=zik project=p1`,
+				`3010 debit 20.00 "Refunds" cc1=zik project=p12`,
+				`2621 debit 6.00 "Refunds" cc1=zik project=p1`,
+				`2611 debit 5.00 "Refunds" cc1=zik project=p12`,
+			},
+		},
+		{
+			name:     "rule outside of its dates",
+			rows:     []izettle.ReportRow{categoryRow("Cider", salesAccount, "25", "125", "Drinks")},
+			payments: []izettle.PaymentRow{payment("IZETTLE_CARD", "125")},
+			want: []string{
+				"1690 debit 125.00 cc1=zik project=p1",
+				"3010 credit 100.00 cc1=zik project=p1",
+				"2611 credit 25.00 cc1=zik project=p1",
+			},
+		},
+	}, testDimensions(t, nil, nil), projects)
+}
+
+func TestNewProjectMapper(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ProjectRule
+	}{
+		{
+			name: "unknown project",
+			rule: ProjectRule{ProjectNumber: "99", Categories: []string{"Pub"}},
+		},
+		{
+			name: "matches everything",
+			rule: ProjectRule{ProjectNumber: "1"},
+		},
+		{
+			name: "from date after the to date",
+			rule: ProjectRule{ProjectNumber: "1", FromDate: util.DateFromStringOrPanic("2020-11-01"), ToDate: util.DateFromStringOrPanic("2020-10-01")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewProjectMapper([]ProjectRule{test.rule}, []visma.Project{uncategorized}, uncategorized)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func onCashRegister(row izettle.ReportRow, name string) izettle.ReportRow {
+	row.CashRegister = izettle.CashRegister{UUID: strings.ToLower(strings.ReplaceAll(name, " ", "-")) + "-uuid", DisplayName: name}
+	return row
+}
+
+func TestDimensionSplit(t *testing.T) {
+	cashRegisters := visma.CostCenter{ID: "registers", Name: "Kassa", Number: 2, Items: []visma.CostCenterItem{
+		{ID: "bar", Name: "Baren", ShortName: "BAR"},
+		{ID: "kiosk", Name: "Kiosken", ShortName: "KIOSK"},
+	}}
+	activities := visma.CostCenter{ID: "activities", Name: "Verksamhet", Number: 3, Items: []visma.CostCenterItem{
+		{ID: "pub", Name: "Pubverksamhet", ShortName: "PUB"},
+	}}
+	dimensions := testDimensions(t, []visma.CostCenter{cashRegisters, activities}, []CostCenterRule{
+		{CostCenter: "Kassa", Item: "Baren", CashRegisters: []string{"Bar iPad"}},
+		{CostCenter: "Kassa", Item: "KIOSK", CashRegisters: []string{"kiosk-ipad-uuid"}},
+		{CostCenter: "3", Item: "PUB", Categories: []string{"Pub"}},
+		{CostCenter: "Kassa", Item: "Kiosken", Categories: []string{"Pub"}},
+	})
+	runVoucherTests(t, []voucherTest{
+		{
+			name: "rows split by cash register",
+			rows: []izettle.ReportRow{
+				onCashRegister(reportRow("Beer", salesAccount, "25", "125"), "Bar iPad"),
+				reportRow("Food", foodAccount, "12", "112"),
+			},
+			payments: []izettle.PaymentRow{payment("IZETTLE_CARD", "237")},
+			want: []string{
+				"1690 debit 237.00 cc1=zik project=p1",
+				"3010 credit 100.00 cc1=zik cc2=bar project=p1",
+				"3020 credit 100.00 cc1=zik project=p1",
+				"2611 credit 25.00 cc1=zik cc2=bar project=p1",
+				"2621 credit 12.00 cc1=zik project=p1",
+			},
+		},
+		{
+			name: "same account on two cash registers",
+			rows: []izettle.ReportRow{
+				onCashRegister(reportRow("Beer", salesAccount, "25", "125"), "Bar iPad"),
+				onCashRegister(reportRow("Lemonade", salesAccount, "25", "50"), "Kiosk iPad"),
+			},
+			payments: []izettle.PaymentRow{payment("IZETTLE_CARD", "175")},
+			want: []string{
+				"1690 debit 175.00 cc1=zik project=p1",
+				"3010 credit 100.00 cc1=zik cc2=bar project=p1",
+				"3010 credit 40.00 cc1=zik cc2=kiosk project=p1",
+				"2611 credit 25.00 cc1=zik cc2=bar project=p1",
+				"2611 credit 10.00 cc1=zik cc2=kiosk project=p1",
+			},
+		},
+		{
+			name: "first matching rule of each dimension",
+			rows: []izettle.ReportRow{
+				onCashRegister(categoryRow("Beer", salesAccount, "25", "125", "Pub"), "Bar iPad"),
+				categoryRow("Cider", salesAccount, "25", "50", "Pub"),
+			},
+			payments: []izettle.PaymentRow{payment("IZETTLE_CARD", "175")},
+			want: []string{
+				"1690 debit 175.00 cc1=zik project=p1",
+				"3010 credit 100.00 cc1=zik cc2=bar cc3=pub project=p1",
+				"3010 credit 40.00 cc1=zik cc2=kiosk cc3=pub project=p1",
+				"2611 credit 25.00 cc1=zik cc2=bar cc3=pub project=p1",
+				"2611 credit 10.00 cc1=zik cc2=kiosk cc3=pub project=p1",
+			},
+		},
+	}, dimensions, testProjects(t, nil, nil))
+}
+
+func TestNewDimensions(t *testing.T) {
+	cashRegisters := visma.CostCenter{ID: "registers", Name: "Kassa", Number: 2, Items: []visma.CostCenterItem{
+		{ID: "bar", Name: "Baren", ShortName: "BAR"},
+	}}
+	tests := []struct {
+		name string
+		rule CostCenterRule
+	}{
+		{
+			name: "unknown cost center",
+			rule: CostCenterRule{CostCenter: "Okänd", Item: "Baren", CashRegisters: []string{"Bar iPad"}},
+		},
+		{
+			name: "unknown item",
+			rule: CostCenterRule{CostCenter: "Kassa", Item: "Okänd", CashRegisters: []string{"Bar iPad"}},
+		},
+		{
+			name: "the committee cost center",
+			rule: CostCenterRule{CostCenter: "Kommitté", Item: "ZIK", CashRegisters: []string{"Bar iPad"}},
+		},
+		{
+			name: "matches everything",
+			rule: CostCenterRule{CostCenter: "Kassa", Item: "Baren"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewDimensions(committee, []visma.CostCenter{committee, cashRegisters}, []CostCenterRule{test.rule})
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestUnknownUser(t *testing.T) {
+	g := testGenerator(t)
+	reports := []izettle.Report{
+		{Date: testDate, Username: "ZIK", Rows: []izettle.ReportRow{reportRow("Beer", salesAccount, "25", "125")}, Payments: []izettle.PaymentRow{payment("IZETTLE_CARD", "125")}},
+		{Date: testDate, Username: "ZOK", Rows: []izettle.ReportRow{reportRow("Beer", salesAccount, "25", "50")}, Payments: []izettle.PaymentRow{payment("IZETTLE_CARD", "50")}},
+	}
+	vouchers, ignored, err := g.GeneratePendingVouchers(reports, testDimensions(t, nil, nil), testProjects(t, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vouchers) != 1 || len(ignored) != 1 || ignored[0].Username != "ZOK" {
+		t.Fatalf("expected the report of ZOK to be ignored, got %d vouchers and %d ignored reports", len(vouchers), len(ignored))
+	}
+	checkVoucher(t, vouchers[0].Voucher, []string{
+		"1690 debit 125.00 cc1=zik project=p1",
+		"3010 credit 100.00 cc1=zik project=p1",
+		"2611 credit 25.00 cc1=zik project=p1",
+	})
+}
@@ -0,0 +1,114 @@
+package generate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"izettle-daily-reports/visma"
+	"os"
+	"strings"
+	"time"
+)
+
+// Journal records every step of an upload, so that an interrupted upload
+// can be resumed without uploading any voucher or attachment twice.
+// It is saved after every step.
+type Journal struct {
+	filename string
+	Entries  map[string]*JournalEntry
+}
+
+// JournalEntry is what has been uploaded of a pending voucher
+type JournalEntry struct {
+	VoucherDate   string
+	VoucherText   string
+	AttachmentIDs []string
+	VoucherID     string
+	Updated       time.Time
+}
+
+// OpenJournal reads the journal, or starts a new one if the file does not exist
+func OpenJournal(filename string) (*Journal, error) {
+	journal := &Journal{filename: filename, Entries: make(map[string]*JournalEntry)}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, journal)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the journal %s: %s", filename, err)
+	}
+	if journal.Entries == nil {
+		journal.Entries = make(map[string]*JournalEntry)
+	}
+	return journal, nil
+}
+
+// JournalKey identifies a pending voucher by its date, text and rows,
+// since it has no ID until it is uploaded.
+func JournalKey(voucher visma.Voucher) string {
+	lines := []string{voucher.VoucherDate.String(), voucher.VoucherText}
+	for _, r := range voucher.Rows {
+		lines = append(lines, fmt.Sprintf("%d;%s;%s;%s;%s;%s;%s;%s", r.AccountNumber, r.DebitAmount.String(), r.CreditAmount.String(),
+			r.TransactionText, r.CostCenterItemID1, r.CostCenterItemID2, r.CostCenterItemID3, r.ProjectID))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Entry returns what has already been uploaded of the voucher
+func (j *Journal) Entry(voucher visma.Voucher) JournalEntry {
+	if e, ok := j.Entries[JournalKey(voucher)]; ok {
+		return *e
+	}
+	return JournalEntry{}
+}
+
+func (j *Journal) AttachmentUploaded(voucher visma.Voucher, attachmentID string) error {
+	e := j.entry(voucher)
+	e.AttachmentIDs = append(e.AttachmentIDs, attachmentID)
+	return j.save()
+}
+
+func (j *Journal) VoucherCreated(voucher visma.Voucher, voucherID string) error {
+	e := j.entry(voucher)
+	e.VoucherID = voucherID
+	return j.save()
+}
+
+// Remove forgets the voucher, e.g. when it has been deleted in visma
+func (j *Journal) Remove(voucher visma.Voucher) error {
+	delete(j.Entries, JournalKey(voucher))
+	return j.save()
+}
+
+func (j *Journal) entry(voucher visma.Voucher) *JournalEntry {
+	key := JournalKey(voucher)
+	e, ok := j.Entries[key]
+	if !ok {
+		e = &JournalEntry{VoucherDate: voucher.VoucherDate.String(), VoucherText: voucher.VoucherText}
+		j.Entries[key] = e
+	}
+	e.Updated = time.Now()
+	return e
+}
+
+// save writes the journal to a temporary file which replaces the journal,
+// so that it is never left half written.
+func (j *Journal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := j.filename + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0664)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.filename)
+}
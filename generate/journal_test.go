@@ -0,0 +1,139 @@
+package generate
+
+import (
+	"io/ioutil"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func journalVoucher(text string, rows ...visma.VoucherRow) visma.Voucher {
+	return visma.Voucher{VoucherDate: util.DateFromStringOrPanic("2020-10-13"), VoucherText: text, Rows: rows}
+}
+
+func TestJournalKey(t *testing.T) {
+	voucher := journalVoucher("Försäljning", debit(ledgerAccount, "125"), credit(salesAccount, "100"), credit(vatAccount, "25"))
+	tests := []struct {
+		name    string
+		voucher visma.Voucher
+		same    bool
+	}{
+		{
+			name:    "same voucher",
+			voucher: journalVoucher("Försäljning", debit(ledgerAccount, "125"), credit(salesAccount, "100"), credit(vatAccount, "25")),
+			same:    true,
+		},
+		{
+			name:    "other text",
+			voucher: journalVoucher("Återköp", debit(ledgerAccount, "125"), credit(salesAccount, "100"), credit(vatAccount, "25")),
+		},
+		{
+			name:    "other amount",
+			voucher: journalVoucher("Försäljning", debit(ledgerAccount, "250"), credit(salesAccount, "200"), credit(vatAccount, "50")),
+		},
+		{
+			name:    "other cost center",
+			voucher: journalVoucher("Försäljning", withCostCenter(debit(ledgerAccount, "125"), "cc1"), credit(salesAccount, "100"), credit(vatAccount, "25")),
+		},
+		{
+			name: "other date",
+			voucher: visma.Voucher{
+				VoucherDate: util.DateFromStringOrPanic("2020-10-14"),
+				VoucherText: "Försäljning",
+				Rows:        voucher.Rows,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := JournalKey(test.voucher) == JournalKey(voucher)
+			if got != test.same {
+				t.Errorf("expected the keys to be the same: %t, got %t", test.same, got)
+			}
+		})
+	}
+}
+
+func TestJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "journal.json")
+	uploaded := journalVoucher("uploaded", debit(ledgerAccount, "125"), credit(salesAccount, "125"))
+	attached := journalVoucher("attached", debit(ledgerAccount, "50"), credit(salesAccount, "50"))
+	removed := journalVoucher("removed", debit(ledgerAccount, "10"), credit(salesAccount, "10"))
+
+	journal, err := OpenJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal.Entries) != 0 {
+		t.Fatalf("expected a new journal, got %d entries", len(journal.Entries))
+	}
+	steps := []error{
+		journal.AttachmentUploaded(uploaded, "a1"),
+		journal.AttachmentUploaded(uploaded, "a2"),
+		journal.VoucherCreated(uploaded, "v1"),
+		journal.AttachmentUploaded(attached, "a3"),
+		journal.VoucherCreated(removed, "v2"),
+		journal.Remove(removed),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	journal, err = OpenJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		voucher       visma.Voucher
+		attachmentIDs []string
+		voucherID     string
+	}{
+		{
+			name:          "uploaded",
+			voucher:       uploaded,
+			attachmentIDs: []string{"a1", "a2"},
+			voucherID:     "v1",
+		},
+		{
+			name:          "only the attachment uploaded",
+			voucher:       attached,
+			attachmentIDs: []string{"a3"},
+		},
+		{
+			name:    "removed",
+			voucher: removed,
+		},
+		{
+			name:    "never uploaded",
+			voucher: journalVoucher("new", debit(ledgerAccount, "1"), credit(salesAccount, "1")),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := journal.Entry(test.voucher)
+			if strings.Join(entry.AttachmentIDs, ",") != strings.Join(test.attachmentIDs, ",") {
+				t.Errorf("expected the attachments %v, got %v", test.attachmentIDs, entry.AttachmentIDs)
+			}
+			if entry.VoucherID != test.voucherID {
+				t.Errorf("expected the voucher %q, got %q", test.voucherID, entry.VoucherID)
+			}
+		})
+	}
+}
+
+func TestOpenInvalidJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "journal.json")
+	err := ioutil.WriteFile(filename, []byte("{"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenJournal(filename)
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"izettle-daily-reports/izettle"
 	"izettle-daily-reports/util"
 	"izettle-daily-reports/visma"
+	"strings"
+
+	"github.com/shopspring/decimal"
 )
 
 type User struct {
@@ -16,23 +19,64 @@ type VismaUser struct {
 	Name string
 }
 
+// IZettleUser is matched by ID if it is set, since the name can be
+// changed in iZettle, otherwise by name.
 type IZettleUser struct {
 	Name string
 	UUID string
+	ID   int
+}
+
+func (u IZettleUser) matches(report izettle.Report) bool {
+	if u.ID != 0 {
+		return u.ID == report.UserID
+	}
+	return u.Name == report.Username
 }
 
 type Matcher struct {
-	ledgerAccountNumber int
-	bankAccountNumbers  []int
-	users               []User
+	ledgerAccountNumber   int
+	bankAccountNumbers    []int
+	feeAccountNumber      int
+	paymentAccountNumbers map[string]int
+	users                 []User
 }
 
-func NewMatcher(ledgerAccountNumber int, bankAccountNumbers []int, users []User) Matcher {
+// NewMatcher creates a matcher for vouchers settled on the ledger account or
+// on one of the payment accounts. The fees of the payouts are booked on
+// feeAccountNumber. paymentAccountNumbers maps an iZettle
+// payment type such as IZETTLE_CASH to the account its sales are debited to,
+// payment types without an account are debited to the ledger account.
+func NewMatcher(ledgerAccountNumber int, bankAccountNumbers []int, feeAccountNumber int, paymentAccountNumbers map[string]int, users []User) Matcher {
 	return Matcher{
-		ledgerAccountNumber: ledgerAccountNumber,
-		bankAccountNumbers:  bankAccountNumbers,
-		users:               users,
+		ledgerAccountNumber:   ledgerAccountNumber,
+		bankAccountNumbers:    bankAccountNumbers,
+		feeAccountNumber:      feeAccountNumber,
+		paymentAccountNumbers: paymentAccountNumbers,
+		users:                 users,
+	}
+}
+
+// PaymentAccountNumber returns the account which sales of the payment type
+// are debited to.
+func (m *Matcher) PaymentAccountNumber(paymentType string) int {
+	if account, ok := m.paymentAccountNumbers[paymentType]; ok {
+		return account
+	}
+	return m.ledgerAccountNumber
+}
+
+// isSettlementAccount returns true if sales can be debited to the account
+func (m *Matcher) isSettlementAccount(accountNumber int) bool {
+	if accountNumber == m.ledgerAccountNumber {
+		return true
+	}
+	for _, n := range m.paymentAccountNumbers {
+		if accountNumber == n {
+			return true
+		}
 	}
+	return false
 }
 
 func (m *Matcher) GetReportCostCenter(report izettle.Report, costCenterItems []visma.CostCenterItem) (*visma.CostCenterItem, error) {
@@ -41,36 +85,57 @@ func (m *Matcher) GetReportCostCenter(report izettle.Report, costCenterItems []v
 			return &cc, nil
 		}
 	}
-	return nil, fmt.Errorf("failed to lookup cost center for report: %s %s", report.Date, report.Username)
+	return nil, fmt.Errorf("failed to lookup cost center for report: %s %s", report.Date.String(), report.Username)
 }
 
+// IsIZettleRelated returns true if the voucher books sales from iZettle. A
+// voucher is only related if it touches the ledger account, or if it was
+// imported and settled on the payment accounts like the vouchers we create,
+// since the payment accounts such as cash are also used by manual vouchers.
 func (m *Matcher) IsIZettleRelated(voucher visma.Voucher) bool {
+	if m.IsPayoutVoucher(voucher) {
+		return false
+	}
 	hasLedger := false
+	hasSettlement := false
 	hasBank := false
 	for _, row := range voucher.Rows {
 		if row.AccountNumber == m.ledgerAccountNumber {
 			hasLedger = true
 		}
+		if m.isSettlementAccount(row.AccountNumber) {
+			// A bank account can also be a payment account, e.g. for Swish,
+			// and then it does not mean that the voucher is a bank transfer.
+			hasSettlement = true
+			continue
+		}
 		for _, n := range m.bankAccountNumbers {
 			if row.AccountNumber == n {
 				hasBank = true
 			}
 		}
 	}
-	return hasLedger && !hasBank
+	if hasBank {
+		return false
+	}
+	return hasLedger || hasSettlement && voucher.VoucherType == visma.SieImport
 }
 
+// GetVoucherCostCenter returns the cost center item of the first settlement
+// row. The items can be in any of the three dimensions.
 func (m *Matcher) GetVoucherCostCenter(voucher visma.Voucher, costCenterItems []visma.CostCenterItem) (*visma.CostCenterItem, error) {
 	for _, row := range voucher.Rows {
-		if row.AccountNumber != m.ledgerAccountNumber {
+		if !m.isSettlementAccount(row.AccountNumber) {
 			continue
 		}
 		for _, costCenterItem := range costCenterItems {
-			if costCenterItem.ID == row.CostCenterItemID1 {
-				return &costCenterItem, nil
+			for dimension := 1; dimension <= 3; dimension++ {
+				if costCenterItem.ID == row.CostCenterItemID(dimension) {
+					return &costCenterItem, nil
+				}
 			}
 		}
-		if row.CostCenterItemID1 == "" {
+		if row.CostCenterItemID1 == "" && row.CostCenterItemID2 == "" && row.CostCenterItemID3 == "" {
 			return nil, fmt.Errorf("voucher does not have a cost center set: %s - %s", voucher.VoucherText, voucher.VoucherDate.String())
 		}
 		return nil, fmt.Errorf("failed to lookup cost center for voucher: %s", voucher.ID)
@@ -78,14 +143,23 @@ func (m *Matcher) GetVoucherCostCenter(voucher visma.Voucher, costCenterItems []
 	return nil, fmt.Errorf("voucher is not imported: %s", voucher.ID)
 }
 
+// GetVoucherSum returns the sum of the sales in the voucher, which is the
+// sum of all the settlement rows. A day with more refunds than sales
+// credits the settlement accounts and gives a negative sum.
 func (m *Matcher) GetVoucherSum(voucher visma.Voucher) (*util.Money, error) {
+	found := false
+	sum := decimal.Zero
 	for _, row := range voucher.Rows {
-		if row.AccountNumber != m.ledgerAccountNumber {
+		if !m.isSettlementAccount(row.AccountNumber) {
 			continue
 		}
-		return &row.DebitAmount, nil
+		found = true
+		sum = sum.Add(row.DebitAmount.Decimal).Sub(row.CreditAmount.Decimal)
 	}
-	return nil, fmt.Errorf("failed to get sum from voucher")
+	if !found {
+		return nil, fmt.Errorf("failed to get sum from voucher")
+	}
+	return &util.Money{Decimal: sum}, nil
 }
 
 func (m *Matcher) isImportedVoucher(voucher visma.Voucher) bool {
@@ -188,9 +262,107 @@ func (m *Matcher) GetUnmatchedVouchers(reports []izettle.Report, vouchers []vism
 
 func (m *Matcher) IsSameUser(report izettle.Report, costCenter visma.CostCenterItem) bool {
 	for _, r := range m.users {
-		if r.Izettle.Name == report.Username && r.Visma.Name == costCenter.ShortName {
+		if r.Izettle.matches(report) && r.Visma.Name == costCenter.ShortName {
 			return true
 		}
 	}
 	return false
 }
+
+// UserWarnings returns a warning for every configured user whose name does
+// not appear in the reports, which happens when a user is renamed in iZettle.
+func (m *Matcher) UserWarnings(reports []izettle.Report) []string {
+	warnings := []string{}
+	for _, u := range m.users {
+		var names []string
+		found := false
+		for _, r := range reports {
+			if u.Izettle.ID != 0 && r.UserID == u.Izettle.ID && !util.ContainsString(names, r.Username) {
+				names = append(names, r.Username)
+			}
+			if r.Username == u.Izettle.Name {
+				found = true
+			}
+		}
+		switch {
+		case found || u.Izettle.Name == "":
+		case len(names) > 0:
+			warnings = append(warnings, fmt.Sprintf("the iZettle user %d is called %s and not %s", u.Izettle.ID, strings.Join(names, ", "), u.Izettle.Name))
+		case u.Izettle.ID == 0:
+			warnings = append(warnings, fmt.Sprintf("no report was made by the iZettle user %s, if the user was renamed set the ID of the user", u.Izettle.Name))
+		}
+	}
+	return warnings
+}
+
+// IsPayoutVoucher returns true if the voucher transfers money from the ledger
+// account to one of the bank accounts. Nothing but the ledger account and the
+// fee account, for refunded fees, may be credited, since a bank account can
+// also be a payment account which is debited by the sales vouchers.
+func (m *Matcher) IsPayoutVoucher(voucher visma.Voucher) bool {
+	hasLedgerCredit := false
+	hasBankDebit := false
+	for _, row := range voucher.Rows {
+		if !row.CreditAmount.IsZero() {
+			if row.AccountNumber == m.ledgerAccountNumber {
+				hasLedgerCredit = true
+			} else if m.feeAccountNumber == 0 || row.AccountNumber != m.feeAccountNumber {
+				return false
+			}
+		}
+		for _, n := range m.bankAccountNumbers {
+			if row.AccountNumber == n && !row.DebitAmount.IsZero() {
+				hasBankDebit = true
+			}
+		}
+	}
+	return hasLedgerCredit && hasBankDebit
+}
+
+// GetPayoutVoucherAmount returns the amount transferred to the bank
+func (m *Matcher) GetPayoutVoucherAmount(voucher visma.Voucher) util.Money {
+	sum := decimal.Zero
+	for _, row := range voucher.Rows {
+		for _, n := range m.bankAccountNumbers {
+			if row.AccountNumber == n {
+				sum = sum.Add(row.DebitAmount.Decimal).Sub(row.CreditAmount.Decimal)
+			}
+		}
+	}
+	return util.Money{Decimal: sum}
+}
+
+// GetUnmatchedPayouts returns the payouts which have not been booked yet. A
+// payout is booked if there is a payout voucher with the same date which
+// transfers the same amount to the bank, no matter how the fees were booked.
+func (m *Matcher) GetUnmatchedPayouts(payouts []izettle.PayoutReport, vouchers []visma.Voucher) []izettle.PayoutReport {
+	var unmatchedPayouts []izettle.PayoutReport
+	used := make(map[int]bool)
+	for _, payout := range payouts {
+		exists := false
+		for i, voucher := range vouchers {
+			if used[i] || !m.IsPayoutVoucher(voucher) {
+				continue
+			}
+			if !voucher.VoucherDate.Equal(payout.Date) {
+				// If the dates do not match,
+				// so we know it can't be the same payout
+				continue
+			}
+			if !m.GetPayoutVoucherAmount(voucher).Equal(payout.Amount.Decimal) {
+				// There can be several payouts on the same day,
+				// so a different amount is not an error
+				continue
+			}
+			// The same voucher should not match two payouts with the same
+			// amount on the same day
+			used[i] = true
+			exists = true
+			break
+		}
+		if !exists {
+			unmatchedPayouts = append(unmatchedPayouts, payout)
+		}
+	}
+	return unmatchedPayouts
+}
@@ -0,0 +1,404 @@
+package generate
+
+import (
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ledgerAccount = 1690
+	bankAccount   = 1930
+	swishAccount  = 1931
+	cashAccount   = 1910
+	feeAccount    = 6570
+	salesAccount  = 3010
+	vatAccount    = 2611
+)
+
+func money(s string) util.Money {
+	return util.Money{Decimal: decimal.RequireFromString(s)}
+}
+
+func debit(account int, amount string) visma.VoucherRow {
+	return visma.VoucherRow{AccountNumber: account, DebitAmount: money(amount)}
+}
+
+func credit(account int, amount string) visma.VoucherRow {
+	return visma.VoucherRow{AccountNumber: account, CreditAmount: money(amount)}
+}
+
+func testMatcher() Matcher {
+	return NewMatcher(ledgerAccount, []int{bankAccount, swishAccount}, feeAccount, map[string]int{
+		"IZETTLE_CASH": cashAccount,
+		"SWISH":        swishAccount,
+	}, []User{
+		{Izettle: IZettleUser{Name: "ZIK"}, Visma: VismaUser{Name: "ZIK"}},
+	})
+}
+
+func TestIsPayoutVoucher(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []visma.VoucherRow
+		want bool
+	}{
+		{
+			name: "payout",
+			rows: []visma.VoucherRow{debit(bankAccount, "98"), debit(feeAccount, "2"), credit(ledgerAccount, "100")},
+			want: true,
+		},
+		{
+			name: "payout with refunded fees",
+			rows: []visma.VoucherRow{debit(bankAccount, "100"), credit(feeAccount, "0.50"), credit(ledgerAccount, "99.50")},
+			want: true,
+		},
+		{
+			name: "payout to a bank account which is also a payment account",
+			rows: []visma.VoucherRow{debit(swishAccount, "100"), credit(ledgerAccount, "100")},
+			want: true,
+		},
+		{
+			name: "sales with card refunds settled on a bank account which is also a payment account",
+			rows: []visma.VoucherRow{
+				debit(swishAccount, "125"), credit(ledgerAccount, "25"),
+				credit(salesAccount, "80"), credit(vatAccount, "20"),
+			},
+			want: false,
+		},
+		{
+			name: "sales",
+			rows: []visma.VoucherRow{debit(ledgerAccount, "100"), credit(salesAccount, "80"), credit(vatAccount, "20")},
+			want: false,
+		},
+		{
+			name: "bank transfer to the ledger account",
+			rows: []visma.VoucherRow{debit(ledgerAccount, "100"), credit(bankAccount, "100")},
+			want: false,
+		},
+	}
+	m := testMatcher()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := m.IsPayoutVoucher(visma.Voucher{Rows: test.rows})
+			if got != test.want {
+				t.Errorf("expected %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func withCostCenter(row visma.VoucherRow, id string) visma.VoucherRow {
+	row.CostCenterItemID1 = id
+	return row
+}
+
+func TestGetVoucherSum(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []visma.VoucherRow
+		want string
+	}{
+		{
+			name: "ledger account",
+			rows: []visma.VoucherRow{debit(ledgerAccount, "125"), credit(salesAccount, "100"), credit(vatAccount, "25")},
+			want: "125",
+		},
+		{
+			name: "several payment accounts",
+			rows: []visma.VoucherRow{
+				debit(ledgerAccount, "75"), debit(cashAccount, "100"), debit(swishAccount, "50"),
+				credit(salesAccount, "180"), credit(vatAccount, "45"),
+			},
+			want: "225",
+		},
+		{
+			name: "refunded cash",
+			rows: []visma.VoucherRow{
+				debit(ledgerAccount, "100"), credit(cashAccount, "30"),
+				credit(salesAccount, "80"), credit(vatAccount, "20"), debit(salesAccount, "24"), debit(vatAccount, "6"),
+			},
+			want: "70",
+		},
+		{
+			name: "more refunds than sales",
+			rows: []visma.VoucherRow{credit(ledgerAccount, "50"), debit(salesAccount, "40"), debit(vatAccount, "10")},
+			want: "-50",
+		},
+	}
+	m := testMatcher()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sum, err := m.GetVoucherSum(visma.Voucher{Rows: test.rows})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sum.Equal(decimal.RequireFromString(test.want)) {
+				t.Errorf("expected %s, got %s", test.want, sum)
+			}
+		})
+	}
+	_, err := m.GetVoucherSum(visma.Voucher{Rows: []visma.VoucherRow{debit(bankAccount, "100"), credit(salesAccount, "100")}})
+	if err == nil {
+		t.Error("expected a voucher without settlement rows to fail")
+	}
+}
+
+func TestIsIZettleRelated(t *testing.T) {
+	tests := []struct {
+		name        string
+		voucherType int
+		rows        []visma.VoucherRow
+		want        bool
+	}{
+		{
+			name:        "imported sales",
+			voucherType: visma.SieImport,
+			rows:        []visma.VoucherRow{debit(cashAccount, "125"), credit(salesAccount, "100"), credit(vatAccount, "25")},
+			want:        true,
+		},
+		{
+			name:        "manual voucher on the ledger account",
+			voucherType: visma.ManualVoucher,
+			rows:        []visma.VoucherRow{debit(ledgerAccount, "125"), credit(salesAccount, "100"), credit(vatAccount, "25")},
+			want:        true,
+		},
+		{
+			name:        "manual voucher on a payment account",
+			voucherType: visma.ManualVoucher,
+			rows:        []visma.VoucherRow{debit(cashAccount, "500"), credit(3990, "500")},
+			want:        false,
+		},
+		{
+			name:        "cash deposited to the bank",
+			voucherType: visma.ManualVoucher,
+			rows:        []visma.VoucherRow{debit(bankAccount, "500"), credit(cashAccount, "500")},
+			want:        false,
+		},
+		{
+			name:        "payout",
+			voucherType: visma.SieImport,
+			rows:        []visma.VoucherRow{debit(bankAccount, "100"), credit(ledgerAccount, "100")},
+			want:        false,
+		},
+	}
+	m := testMatcher()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := m.IsIZettleRelated(visma.Voucher{VoucherType: test.voucherType, Rows: test.rows})
+			if got != test.want {
+				t.Errorf("expected %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func TestGetUnmatchedReports(t *testing.T) {
+	date := util.DateFromStringOrPanic("2020-10-13")
+	report := izettle.Report{Date: date, Username: "ZIK", Rows: []izettle.ReportRow{{Name: "Beer", Amount: money("125")}}}
+	voucher := func(voucherType int, rows ...visma.VoucherRow) visma.Voucher {
+		return visma.Voucher{ID: "v", VoucherDate: date, VoucherType: voucherType, Rows: rows}
+	}
+	tests := []struct {
+		name      string
+		voucher   visma.Voucher
+		unmatched int
+		fails     bool
+	}{
+		{
+			name:    "imported voucher",
+			voucher: voucher(visma.SieImport, withCostCenter(debit(ledgerAccount, "125"), "zik"), credit(salesAccount, "100"), credit(vatAccount, "25")),
+		},
+		{
+			name:      "manual voucher on a payment account",
+			voucher:   voucher(visma.ManualVoucher, debit(cashAccount, "125"), credit(3990, "125")),
+			unmatched: 1,
+		},
+		{
+			name:    "voucher on the ledger account without a cost center",
+			voucher: voucher(visma.ManualVoucher, debit(ledgerAccount, "125"), credit(salesAccount, "100"), credit(vatAccount, "25")),
+			fails:   true,
+		},
+		{
+			name:    "the same day and user but not the same sum",
+			voucher: voucher(visma.SieImport, withCostCenter(debit(ledgerAccount, "100"), "zik"), credit(salesAccount, "80"), credit(vatAccount, "20")),
+			fails:   true,
+		},
+	}
+	m := testMatcher()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unmatched, err := m.GetUnmatchedReports([]izettle.Report{report}, []visma.Voucher{test.voucher}, committee.Items)
+			if test.fails {
+				if err == nil {
+					t.Error("expected the matching to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(unmatched) != test.unmatched {
+				t.Errorf("expected %d unmatched reports, got %d", test.unmatched, len(unmatched))
+			}
+		})
+	}
+}
+
+func TestGetUnmatchedPayouts(t *testing.T) {
+	payout := func(date string, amount string) izettle.PayoutReport {
+		return izettle.PayoutReport{Date: util.DateFromStringOrPanic(date), Amount: money(amount)}
+	}
+	voucher := func(date string, rows ...visma.VoucherRow) visma.Voucher {
+		return visma.Voucher{VoucherDate: util.DateFromStringOrPanic(date), Rows: rows}
+	}
+	tests := []struct {
+		name     string
+		payouts  []izettle.PayoutReport
+		vouchers []visma.Voucher
+		want     []string
+	}{
+		{
+			name:     "booked payout",
+			payouts:  []izettle.PayoutReport{payout("2020-10-05", "98")},
+			vouchers: []visma.Voucher{voucher("2020-10-05", debit(bankAccount, "98"), debit(feeAccount, "2"), credit(ledgerAccount, "100"))},
+			want:     []string{},
+		},
+		{
+			name:     "another day",
+			payouts:  []izettle.PayoutReport{payout("2020-10-05", "98")},
+			vouchers: []visma.Voucher{voucher("2020-10-06", debit(bankAccount, "98"), debit(feeAccount, "2"), credit(ledgerAccount, "100"))},
+			want:     []string{"2020-10-05 98"},
+		},
+		{
+			name:     "two payouts with the same amount on the same day",
+			payouts:  []izettle.PayoutReport{payout("2020-10-05", "98"), payout("2020-10-05", "98")},
+			vouchers: []visma.Voucher{voucher("2020-10-05", debit(bankAccount, "98"), debit(feeAccount, "2"), credit(ledgerAccount, "100"))},
+			want:     []string{"2020-10-05 98"},
+		},
+		{
+			name:     "sales settled on a bank account",
+			payouts:  []izettle.PayoutReport{payout("2020-10-05", "125")},
+			vouchers: []visma.Voucher{voucher("2020-10-05", debit(swishAccount, "125"), credit(ledgerAccount, "25"), credit(salesAccount, "100"))},
+			want:     []string{"2020-10-05 125"},
+		},
+	}
+	m := testMatcher()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			for _, p := range m.GetUnmatchedPayouts(test.payouts, test.vouchers) {
+				got = append(got, p.Date.String()+" "+p.Amount.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func TestIsSameUser(t *testing.T) {
+	users := []User{
+		{Izettle: IZettleUser{ID: 7, Name: "ZIK"}, Visma: VismaUser{Name: "ZIK"}},
+		{Izettle: IZettleUser{Name: "ZOK"}, Visma: VismaUser{Name: "ZOK"}},
+	}
+	tests := []struct {
+		name       string
+		report     izettle.Report
+		costCenter string
+		want       bool
+	}{
+		{
+			name:       "same ID and name",
+			report:     izettle.Report{UserID: 7, Username: "ZIK"},
+			costCenter: "ZIK",
+			want:       true,
+		},
+		{
+			name:       "renamed user with the same ID",
+			report:     izettle.Report{UserID: 7, Username: "ZIK 2020"},
+			costCenter: "ZIK",
+			want:       true,
+		},
+		{
+			name:       "same name but another ID",
+			report:     izettle.Report{UserID: 8, Username: "ZIK"},
+			costCenter: "ZIK",
+			want:       false,
+		},
+		{
+			name:       "user without ID by name",
+			report:     izettle.Report{UserID: 9, Username: "ZOK"},
+			costCenter: "ZOK",
+			want:       true,
+		},
+		{
+			name:       "other cost center",
+			report:     izettle.Report{UserID: 7, Username: "ZIK"},
+			costCenter: "ZOK",
+			want:       false,
+		},
+	}
+	m := NewMatcher(ledgerAccount, []int{bankAccount}, feeAccount, nil, users)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := m.IsSameUser(test.report, visma.CostCenterItem{ShortName: test.costCenter})
+			if got != test.want {
+				t.Errorf("expected %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func TestUserWarnings(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    IZettleUser
+		reports []izettle.Report
+		want    []string
+	}{
+		{
+			name:    "user found",
+			user:    IZettleUser{ID: 7, Name: "ZIK"},
+			reports: []izettle.Report{{UserID: 7, Username: "ZIK"}},
+			want:    []string{},
+		},
+		{
+			name:    "user only matched by ID",
+			user:    IZettleUser{ID: 7},
+			reports: []izettle.Report{{UserID: 7, Username: "ZIK"}},
+			want:    []string{},
+		},
+		{
+			name:    "renamed user with an ID",
+			user:    IZettleUser{ID: 7, Name: "ZIK"},
+			reports: []izettle.Report{{UserID: 7, Username: "ZIK 2020"}, {UserID: 7, Username: "ZIK 2020"}, {UserID: 7, Username: "ZAK"}},
+			want:    []string{"the iZettle user 7 is called ZIK 2020, ZAK and not ZIK"},
+		},
+		{
+			name:    "user without an ID not found",
+			user:    IZettleUser{Name: "ZIK"},
+			reports: []izettle.Report{{UserID: 7, Username: "ZIK 2020"}},
+			want:    []string{"no report was made by the iZettle user ZIK, if the user was renamed set the ID of the user"},
+		},
+		{
+			name:    "user with an ID without reports",
+			user:    IZettleUser{ID: 7, Name: "ZIK"},
+			reports: []izettle.Report{{UserID: 8, Username: "ZOK"}},
+			want:    []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMatcher(ledgerAccount, []int{bankAccount}, feeAccount, nil, []User{{Izettle: test.user, Visma: VismaUser{Name: "ZIK"}}})
+			got := m.UserWarnings(test.reports)
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
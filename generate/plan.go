@@ -0,0 +1,124 @@
+package generate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Plan is a reviewable list of vouchers which should be uploaded to visma.
+// It is written by the plan command and uploaded as is by the apply command.
+type Plan struct {
+	Created     time.Time
+	Environment string
+	FromDate    util.Date
+	ToDate      util.Date
+	// VismaState is a fingerprint of the vouchers in visma between FromDate
+	// and ToDate when the plan was made.
+	VismaState string
+	Vouchers   []PlannedVoucher
+}
+
+// PlannedVoucher is a voucher with the paths to its attachments,
+// the paths are relative to the plan file.
+type PlannedVoucher struct {
+	Voucher         visma.Voucher
+	Attachments     []string
+	AttachmentNames []string
+}
+
+func NewPlan(environment string, fromDate, toDate util.Date, vouchers []visma.Voucher, pendingVouchers []PendingVoucher) Plan {
+	plan := Plan{
+		Created:     time.Now(),
+		Environment: environment,
+		FromDate:    fromDate,
+		ToDate:      toDate,
+		VismaState:  VoucherFingerprint(vouchers),
+	}
+	for _, v := range pendingVouchers {
+		plan.Vouchers = append(plan.Vouchers, PlannedVoucher{Voucher: v.Voucher, AttachmentNames: v.AttachmentNames})
+	}
+	return plan
+}
+
+// VoucherFingerprint returns a hash which changes if any of the vouchers are
+// added, removed or modified.
+func VoucherFingerprint(vouchers []visma.Voucher) string {
+	ids := make([]string, len(vouchers))
+	for i, v := range vouchers {
+		ids[i] = v.ID + "@" + v.ModifiedUtc.UTC().Format(time.RFC3339Nano)
+	}
+	sort.Strings(ids)
+	sum := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify returns an error if the vouchers in visma have changed since the
+// plan was made, since the plan might then import duplicates.
+func (p *Plan) Verify(vouchers []visma.Voucher) error {
+	if VoucherFingerprint(vouchers) != p.VismaState {
+		return fmt.Errorf("the vouchers in visma have changed since the plan was made at %s, please make a new plan", p.Created.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// Write saves the plan as json and the attachments of the pending vouchers
+// as separate files next to it.
+func (p *Plan) Write(filename string, pendingVouchers []PendingVoucher) error {
+	base := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	for i, v := range pendingVouchers {
+		p.Vouchers[i].Attachments = nil
+		for j, data := range v.Attachments {
+			attachment := fmt.Sprintf("%s-%d-%d.pdf", base, i+1, j+1)
+			err := ioutil.WriteFile(filepath.Join(filepath.Dir(filename), attachment), data, 0664)
+			if err != nil {
+				return err
+			}
+			p.Vouchers[i].Attachments = append(p.Vouchers[i].Attachments, attachment)
+		}
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0664)
+}
+
+func ReadPlan(filename string) (*Plan, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{}
+	err = json.Unmarshal(data, plan)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PendingVouchers reads the attachments of the plan, filename is the path
+// the plan was read from.
+func (p *Plan) PendingVouchers(filename string) ([]PendingVoucher, error) {
+	pendingVouchers := []PendingVoucher{}
+	for _, v := range p.Vouchers {
+		pending := PendingVoucher{Voucher: v.Voucher, AttachmentNames: v.AttachmentNames}
+		for _, attachment := range v.Attachments {
+			data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(filename), attachment))
+			if err != nil {
+				return nil, err
+			}
+			pending.Attachments = append(pending.Attachments, data)
+		}
+		pendingVouchers = append(pendingVouchers, pending)
+	}
+	return pendingVouchers, nil
+}
@@ -0,0 +1,142 @@
+package generate
+
+import (
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func modifiedVoucher(id string, modified string) visma.Voucher {
+	modifiedUtc, err := time.Parse(time.RFC3339, modified)
+	if err != nil {
+		panic(err)
+	}
+	return visma.Voucher{ID: id, ModifiedUtc: modifiedUtc}
+}
+
+func TestPlanVerify(t *testing.T) {
+	planned := []visma.Voucher{
+		modifiedVoucher("a", "2020-10-13T10:00:00Z"),
+		modifiedVoucher("b", "2020-10-14T10:00:00Z"),
+	}
+	tests := []struct {
+		name     string
+		vouchers []visma.Voucher
+		wantErr  bool
+	}{
+		{
+			name:     "unchanged",
+			vouchers: planned,
+		},
+		{
+			name: "other order",
+			vouchers: []visma.Voucher{
+				modifiedVoucher("b", "2020-10-14T10:00:00Z"),
+				modifiedVoucher("a", "2020-10-13T10:00:00Z"),
+			},
+		},
+		{
+			name: "same time in another zone",
+			vouchers: []visma.Voucher{
+				modifiedVoucher("a", "2020-10-13T12:00:00+02:00"),
+				modifiedVoucher("b", "2020-10-14T10:00:00Z"),
+			},
+		},
+		{
+			name: "modified",
+			vouchers: []visma.Voucher{
+				modifiedVoucher("a", "2020-10-13T10:00:00Z"),
+				modifiedVoucher("b", "2020-10-15T10:00:00Z"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "added",
+			vouchers: []visma.Voucher{
+				modifiedVoucher("a", "2020-10-13T10:00:00Z"),
+				modifiedVoucher("b", "2020-10-14T10:00:00Z"),
+				modifiedVoucher("c", "2020-10-15T10:00:00Z"),
+			},
+			wantErr: true,
+		},
+		{
+			name:     "removed",
+			vouchers: []visma.Voucher{modifiedVoucher("a", "2020-10-13T10:00:00Z")},
+			wantErr:  true,
+		},
+	}
+	from := util.DateFromStringOrPanic("2020-10-01")
+	to := util.DateFromStringOrPanic("2020-10-31")
+	plan := NewPlan("test", from, to, planned, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := plan.Verify(test.vouchers)
+			if test.wantErr && err == nil {
+				t.Error("expected an error")
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestPlanWriteAndRead(t *testing.T) {
+	from := util.DateFromStringOrPanic("2020-10-01")
+	to := util.DateFromStringOrPanic("2020-10-31")
+	existing := []visma.Voucher{modifiedVoucher("a", "2020-10-13T10:00:00Z")}
+	pendingVouchers := []PendingVoucher{
+		{
+			Voucher:         visma.Voucher{VoucherDate: from, VoucherText: "first", Rows: []visma.VoucherRow{debit(ledgerAccount, "125"), credit(salesAccount, "125")}},
+			Attachments:     [][]byte{[]byte("first pdf"), []byte("second pdf")},
+			AttachmentNames: []string{"first.pdf", "second.pdf"},
+		},
+		{
+			Voucher: visma.Voucher{VoucherDate: to, VoucherText: "without attachments"},
+		},
+	}
+	filename := filepath.Join(t.TempDir(), "plan.json")
+	plan := NewPlan("test", from, to, existing, pendingVouchers)
+	err := plan.Write(filename, pendingVouchers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadPlan(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Environment != "test" || read.FromDate != from || read.ToDate != to {
+		t.Errorf("unexpected plan: %s %s - %s", read.Environment, read.FromDate.String(), read.ToDate.String())
+	}
+	if err := read.Verify(existing); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	got, err := read.PendingVouchers(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(pendingVouchers) {
+		t.Fatalf("expected %d vouchers, got %d", len(pendingVouchers), len(got))
+	}
+	wantRows := [][]string{{"1690 debit 125.00", "3010 credit 125.00"}, {}}
+	for i, want := range pendingVouchers {
+		if got[i].Voucher.VoucherText != want.Voucher.VoucherText || got[i].Voucher.VoucherDate != want.Voucher.VoucherDate {
+			t.Errorf("expected %s %s, got %s %s", want.Voucher.VoucherDate.String(), want.Voucher.VoucherText, got[i].Voucher.VoucherDate.String(), got[i].Voucher.VoucherText)
+		}
+		checkVoucher(t, got[i].Voucher, wantRows[i])
+		if len(got[i].Attachments) != len(want.Attachments) {
+			t.Fatalf("expected %d attachments, got %d", len(want.Attachments), len(got[i].Attachments))
+		}
+		for j := range want.Attachments {
+			if string(got[i].Attachments[j]) != string(want.Attachments[j]) {
+				t.Errorf("expected %s, got %s", want.Attachments[j], got[i].Attachments[j])
+			}
+			if got[i].AttachmentNames[j] != want.AttachmentNames[j] {
+				t.Errorf("expected %s, got %s", want.AttachmentNames[j], got[i].AttachmentNames[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package generate
+
+import (
+	"fmt"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"sort"
+)
+
+// ProjectRule books the products matching the rule on a visma project.
+// A rule matches a row when every condition which is set matches, the
+// categories are matched by name or UUID. FromDate and ToDate are included.
+type ProjectRule struct {
+	ProjectNumber string
+	Categories    []string
+	ProductUUIDs  []string
+	FromDate      util.Date
+	ToDate        util.Date
+}
+
+type projectRule struct {
+	ProjectRule
+	project visma.Project
+}
+
+// ProjectMapper finds the project of each report row, using the first
+// matching rule or the uncategorized project if no rule matches.
+type ProjectMapper struct {
+	rules         []projectRule
+	uncategorized visma.Project
+}
+
+// NewProjectMapper makes sure that the project of every rule exists in visma
+func NewProjectMapper(rules []ProjectRule, projects []visma.Project, uncategorized visma.Project) (ProjectMapper, error) {
+	m := ProjectMapper{uncategorized: uncategorized}
+	for i, rule := range rules {
+		var project *visma.Project
+		for j := range projects {
+			if projects[j].Number == rule.ProjectNumber {
+				project = &projects[j]
+				break
+			}
+		}
+		if project == nil {
+			return m, fmt.Errorf("project rule %d: unable to find project with number: %s", i+1, rule.ProjectNumber)
+		}
+		if len(rule.Categories) == 0 && len(rule.ProductUUIDs) == 0 && rule.FromDate.Time().IsZero() && rule.ToDate.Time().IsZero() {
+			return m, fmt.Errorf("project rule %d: the rule for project %s matches everything", i+1, rule.ProjectNumber)
+		}
+		if !rule.ToDate.Time().IsZero() && rule.FromDate.After(rule.ToDate) {
+			return m, fmt.Errorf("project rule %d: the from date is after the to date", i+1)
+		}
+		m.rules = append(m.rules, projectRule{ProjectRule: rule, project: *project})
+	}
+	return m, nil
+}
+
+func (m ProjectMapper) Uncategorized() visma.Project {
+	return m.uncategorized
+}
+
+// ProjectReport is the part of a report which is booked on the project
+type ProjectReport struct {
+	Project visma.Project
+	Report  izettle.Report
+}
+
+// Split splits the rows of the report by project, ordered by project number
+func (m ProjectMapper) Split(report izettle.Report) []ProjectReport {
+	parts := []ProjectReport{}
+	for _, row := range report.Rows {
+		project := m.Project(report.Date, row)
+		i := 0
+		for i < len(parts) && parts[i].Project.ID != project.ID {
+			i++
+		}
+		if i == len(parts) {
+			part := report
+			part.Rows = nil
+			parts = append(parts, ProjectReport{Project: project, Report: part})
+		}
+		parts[i].Report.Rows = append(parts[i].Report.Rows, row)
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return parts[i].Project.Number < parts[j].Project.Number
+	})
+	return parts
+}
+
+// Project returns the project of a row of a report made on the date
+func (m ProjectMapper) Project(date util.Date, row izettle.ReportRow) visma.Project {
+	for _, rule := range m.rules {
+		if rule.matches(date, row) {
+			return rule.project
+		}
+	}
+	return m.uncategorized
+}
+
+func (r projectRule) matches(date util.Date, row izettle.ReportRow) bool {
+	if !r.FromDate.Time().IsZero() && date.Before(r.FromDate) {
+		return false
+	}
+	if !r.ToDate.Time().IsZero() && date.After(r.ToDate) {
+		return false
+	}
+	if len(r.Categories) > 0 && (row.Category.UUID == "" ||
+		!util.ContainsString(r.Categories, row.Category.Name) && !util.ContainsString(r.Categories, row.Category.UUID)) {
+		return false
+	}
+	if len(r.ProductUUIDs) > 0 && !util.ContainsString(r.ProductUUIDs, row.ProductUUID) {
+		return false
+	}
+	return true
+}
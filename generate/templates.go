@@ -0,0 +1,103 @@
+package generate
+
+import (
+	"bytes"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/visma"
+	"text/template"
+)
+
+const DefaultVoucherText = "Uncategorized iZettle Import"
+const DefaultTransactionText = "{{if .Row.Refund}}Refunds{{end}}"
+const DefaultAttachmentName = "Autogenerated_{{.CostCenter.ID}}_{{.Date}}{{if gt .Attachment 1}}_{{.Attachment}}{{end}}.pdf"
+
+// MaxTransactionTextLength is the longest transaction text visma accepts
+const MaxTransactionTextLength = 60
+
+// Templates are the text/template templates of the texts of the generated
+// sales vouchers, they are executed with TemplateData.
+type Templates struct {
+	voucherText     *template.Template
+	transactionText *template.Template
+	attachmentName  *template.Template
+}
+
+// TemplateData is what the templates have access to. Row is only set for
+// the transaction text and Attachment, counting from 1, for the attachment name.
+type TemplateData struct {
+	Report     izettle.Report
+	CostCenter visma.CostCenterItem
+	// Date is the date of the report formatted as 2006-01-02
+	Date       string
+	Row        izettle.VismaRow
+	Attachment int
+}
+
+// ParseTemplates parses the templates, the default template is used for
+// each template which is empty.
+func ParseTemplates(voucherText, transactionText, attachmentName string) (Templates, error) {
+	var t Templates
+	var err error
+	t.voucherText, err = parseTemplate("voucher text", voucherText, DefaultVoucherText)
+	if err != nil {
+		return t, err
+	}
+	t.transactionText, err = parseTemplate("transaction text", transactionText, DefaultTransactionText)
+	if err != nil {
+		return t, err
+	}
+	t.attachmentName, err = parseTemplate("attachment name", attachmentName, DefaultAttachmentName)
+	return t, err
+}
+
+func parseTemplate(name, text, defaultText string) (*template.Template, error) {
+	if text == "" {
+		text = defaultText
+	}
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+func newTemplateData(report izettle.Report, costCenter visma.CostCenterItem) TemplateData {
+	return TemplateData{
+		Report:     report,
+		CostCenter: costCenter,
+		Date:       report.Date.String(),
+	}
+}
+
+func (t Templates) VoucherText(data TemplateData) (string, error) {
+	return execute(t.voucherText, data)
+}
+
+// TransactionText is truncated to the length visma accepts
+func (t Templates) TransactionText(data TemplateData, row izettle.VismaRow) (string, error) {
+	data.Row = row
+	text, err := execute(t.transactionText, data)
+	if err != nil {
+		return "", err
+	}
+	return truncate(text, MaxTransactionTextLength), nil
+}
+
+func (t Templates) AttachmentName(data TemplateData, attachment int) (string, error) {
+	data.Attachment = attachment
+	return execute(t.attachmentName, data)
+}
+
+func execute(t *template.Template, data TemplateData) (string, error) {
+	var b bytes.Buffer
+	err := t.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// truncate shortens the text to at most max characters, ending with "..."
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-3]) + "..."
+}
@@ -0,0 +1,189 @@
+package generate
+
+import (
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
+	"izettle-daily-reports/visma"
+	"strings"
+	"testing"
+)
+
+func templateData() TemplateData {
+	report := izettle.Report{Date: util.DateFromStringOrPanic("2020-10-13"), Username: "ZIK"}
+	return newTemplateData(report, visma.CostCenterItem{ID: "zik", Name: "ZIK"})
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "short",
+			text: "Försäljning",
+			want: "Försäljning",
+		},
+		{
+			name: "exactly the max length",
+			text: strings.Repeat("a", MaxTransactionTextLength),
+			want: strings.Repeat("a", MaxTransactionTextLength),
+		},
+		{
+			name: "too long",
+			text: strings.Repeat("a", MaxTransactionTextLength+1),
+			want: strings.Repeat("a", MaxTransactionTextLength-3) + "...",
+		},
+		{
+			name: "multi-byte characters are counted as one",
+			text: strings.Repeat("ö", MaxTransactionTextLength),
+			want: strings.Repeat("ö", MaxTransactionTextLength),
+		},
+		{
+			name: "too long with multi-byte characters",
+			text: strings.Repeat("å", MaxTransactionTextLength+5),
+			want: strings.Repeat("å", MaxTransactionTextLength-3) + "...",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := truncate(test.text, MaxTransactionTextLength)
+			if got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestTransactionText(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		row      izettle.VismaRow
+		want     string
+	}{
+		{
+			name: "default sale",
+			row:  izettle.VismaRow{VismaAccount: salesAccount},
+			want: "",
+		},
+		{
+			name: "default refund",
+			row:  izettle.VismaRow{VismaAccount: salesAccount, Refund: true},
+			want: "Refunds",
+		},
+		{
+			name:     "custom",
+			template: "{{.CostCenter.Name}} {{.Date}} {{.Row.VismaAccount}}",
+			row:      izettle.VismaRow{VismaAccount: salesAccount},
+			want:     "ZIK 2020-10-13 3010",
+		},
+		{
+			name:     "truncated",
+			template: "{{.Report.Username}} " + strings.Repeat("ä", MaxTransactionTextLength),
+			row:      izettle.VismaRow{VismaAccount: salesAccount},
+			want:     "ZIK " + strings.Repeat("ä", MaxTransactionTextLength-7) + "...",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates, err := ParseTemplates("", test.template, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := templates.TransactionText(templateData(), test.row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestVoucherTextAndAttachmentName(t *testing.T) {
+	tests := []struct {
+		name           string
+		voucherText    string
+		attachmentName string
+		attachment     int
+		wantText       string
+		wantName       string
+	}{
+		{
+			name:       "defaults",
+			attachment: 1,
+			wantText:   DefaultVoucherText,
+			wantName:   "Autogenerated_zik_2020-10-13.pdf",
+		},
+		{
+			name:       "default name of the second attachment",
+			attachment: 2,
+			wantText:   DefaultVoucherText,
+			wantName:   "Autogenerated_zik_2020-10-13_2.pdf",
+		},
+		{
+			name:           "custom",
+			voucherText:    "Försäljning {{.CostCenter.Name}} {{.Date}}",
+			attachmentName: "{{.Report.Username}}-{{.Attachment}}.pdf",
+			attachment:     3,
+			wantText:       "Försäljning ZIK 2020-10-13",
+			wantName:       "ZIK-3.pdf",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates, err := ParseTemplates(test.voucherText, "", test.attachmentName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, err := templates.VoucherText(templateData())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != test.wantText {
+				t.Errorf("expected %q, got %q", test.wantText, text)
+			}
+			name, err := templates.AttachmentName(templateData(), test.attachment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != test.wantName {
+				t.Errorf("expected %q, got %q", test.wantName, name)
+			}
+		})
+	}
+}
+
+func TestInvalidTemplates(t *testing.T) {
+	tests := []struct {
+		name            string
+		voucherText     string
+		transactionText string
+		attachmentName  string
+	}{
+		{name: "voucher text", voucherText: "{{.Date"},
+		{name: "transaction text", transactionText: "{{if .Row.Refund}}"},
+		{name: "attachment name", attachmentName: "{{end}}"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseTemplates(test.voucherText, test.transactionText, test.attachmentName)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestUnknownTemplateField(t *testing.T) {
+	templates, err := ParseTemplates("{{.Unknown}}", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = templates.VoucherText(templateData())
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
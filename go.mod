@@ -11,6 +11,8 @@ require (
 	github.com/golang/protobuf v1.3.2 // indirect
 	github.com/pkg/browser v0.0.0-20180916011732-0a3d74bf9ce4
 	github.com/shopspring/decimal v0.0.0-20191130220710-360f2bc03045
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/crypto v0.0.0-20190605123033-f99c8df09eb5
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	google.golang.org/appengine v1.6.2 // indirect
 )
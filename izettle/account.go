@@ -0,0 +1,117 @@
+package izettle
+
+import (
+	"fmt"
+	"izettle-daily-reports/util"
+	"regexp"
+	"strconv"
+)
+
+// AccountRule books the products matching the rule on the visma account.
+// A rule matches when every condition which is set matches, the categories
+// are matched by name or UUID and NameRegex by the name of the product and
+// variant, e.g. "Beer, Large".
+type AccountRule struct {
+	AccountNumber int
+	ProductUUIDs  []string
+	VariantUUIDs  []string
+	Categories    []string
+	NameRegex     string
+}
+
+type accountRule struct {
+	AccountRule
+	name *regexp.Regexp
+}
+
+// AccountResolver finds the visma account of each product variant. The
+// first matching rule is used, then the barcode if it is a number and barcodes
+// are used. Custom products which are not in the library are always booked
+// on the default account.
+type AccountResolver struct {
+	rules                []accountRule
+	useBarcodes          bool
+	defaultAccountNumber int
+}
+
+func NewAccountResolver(rules []AccountRule, useBarcodes bool, defaultAccountNumber int) (AccountResolver, error) {
+	r := AccountResolver{useBarcodes: useBarcodes, defaultAccountNumber: defaultAccountNumber}
+	for i, rule := range rules {
+		if rule.AccountNumber == 0 {
+			return r, fmt.Errorf("account rule %d does not have an account number", i+1)
+		}
+		if len(rule.ProductUUIDs) == 0 && len(rule.VariantUUIDs) == 0 && len(rule.Categories) == 0 && rule.NameRegex == "" {
+			return r, fmt.Errorf("account rule %d for account %d matches everything", i+1, rule.AccountNumber)
+		}
+		compiled := accountRule{AccountRule: rule}
+		if rule.NameRegex != "" {
+			var err error
+			compiled.name, err = regexp.Compile(rule.NameRegex)
+			if err != nil {
+				return r, fmt.Errorf("account rule %d has an invalid name regex: %s", i+1, err)
+			}
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// Account returns the account of the variant of a product in the library,
+// or 0 if it does not resolve to any account.
+func (r AccountResolver) Account(product Product, variant Variant) int {
+	name := variantName(product, variant)
+	for _, rule := range r.rules {
+		if rule.matches(product, variant, name) {
+			return rule.AccountNumber
+		}
+	}
+	if r.useBarcodes {
+		if barcode, err := strconv.Atoi(variant.Barcode); err == nil && barcode > 0 {
+			return barcode
+		}
+	}
+	return 0
+}
+
+// DefaultAccount is the account of custom products which are not in the library
+func (r AccountResolver) DefaultAccount() int {
+	return r.defaultAccountNumber
+}
+
+// Unresolved returns the names of every variant in the library which does not
+// resolve to an account.
+func (r AccountResolver) Unresolved(products []Product) []string {
+	unresolved := []string{}
+	for _, p := range products {
+		for _, v := range p.Variants {
+			if r.Account(p, v) == 0 {
+				unresolved = append(unresolved, fmt.Sprintf("%s (variant %s, barcode %q)", variantName(p, v), v.UUID, v.Barcode))
+			}
+		}
+	}
+	return unresolved
+}
+
+func (r accountRule) matches(product Product, variant Variant, name string) bool {
+	if len(r.ProductUUIDs) > 0 && !util.ContainsString(r.ProductUUIDs, product.UUID) {
+		return false
+	}
+	if len(r.VariantUUIDs) > 0 && !util.ContainsString(r.VariantUUIDs, variant.UUID) {
+		return false
+	}
+	if len(r.Categories) > 0 && (product.Category.UUID == "" ||
+		!util.ContainsString(r.Categories, product.Category.Name) && !util.ContainsString(r.Categories, product.Category.UUID)) {
+		return false
+	}
+	if r.name != nil && !r.name.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+func variantName(product Product, variant Variant) string {
+	if variant.Name == "" {
+		return product.Name
+	}
+	return product.Name + ", " + variant.Name
+}
@@ -0,0 +1,184 @@
+package izettle
+
+import (
+	"strings"
+	"testing"
+)
+
+var (
+	beer = Product{
+		UUID:     "beer-uuid",
+		Name:     "Beer",
+		Category: Category{UUID: "pub-uuid", Name: "Pub"},
+		Variants: []Variant{
+			{UUID: "small-uuid", Name: "Small", Barcode: "3011"},
+			{UUID: "large-uuid", Name: "Large", Barcode: "3012"},
+		},
+	}
+	sandwich = Product{
+		UUID:     "sandwich-uuid",
+		Name:     "Sandwich",
+		Variants: []Variant{{UUID: "sandwich-variant-uuid", Barcode: "7310865004703a"}},
+	}
+)
+
+func TestAccountResolver(t *testing.T) {
+	tests := []struct {
+		name        string
+		rules       []AccountRule
+		useBarcodes bool
+		product     Product
+		variant     Variant
+		want        int
+	}{
+		{
+			name:    "category by name",
+			rules:   []AccountRule{{AccountNumber: 3020, Categories: []string{"Pub"}}},
+			product: beer,
+			variant: beer.Variants[0],
+			want:    3020,
+		},
+		{
+			name:    "category by UUID",
+			rules:   []AccountRule{{AccountNumber: 3020, Categories: []string{"pub-uuid"}}},
+			product: beer,
+			variant: beer.Variants[0],
+			want:    3020,
+		},
+		{
+			name:    "product without a category",
+			rules:   []AccountRule{{AccountNumber: 3020, Categories: []string{""}}},
+			product: sandwich,
+			variant: sandwich.Variants[0],
+			want:    0,
+		},
+		{
+			name:    "variant",
+			rules:   []AccountRule{{AccountNumber: 3021, VariantUUIDs: []string{"large-uuid"}}},
+			product: beer,
+			variant: beer.Variants[0],
+			want:    0,
+		},
+		{
+			name:    "name of the product and variant",
+			rules:   []AccountRule{{AccountNumber: 3022, NameRegex: "^Beer, L"}},
+			product: beer,
+			variant: beer.Variants[1],
+			want:    3022,
+		},
+		{
+			name:    "name of a product without variant name",
+			rules:   []AccountRule{{AccountNumber: 3023, NameRegex: "^Sandwich$"}},
+			product: sandwich,
+			variant: sandwich.Variants[0],
+			want:    3023,
+		},
+		{
+			name: "every condition must match",
+			rules: []AccountRule{
+				{AccountNumber: 3024, Categories: []string{"Pub"}, NameRegex: "Cider"},
+			},
+			product: beer,
+			variant: beer.Variants[0],
+			want:    0,
+		},
+		{
+			name: "first matching rule wins",
+			rules: []AccountRule{
+				{AccountNumber: 3025, ProductUUIDs: []string{"beer-uuid"}},
+				{AccountNumber: 3026, VariantUUIDs: []string{"small-uuid"}},
+			},
+			product: beer,
+			variant: beer.Variants[0],
+			want:    3025,
+		},
+		{
+			name:        "rules before barcodes",
+			rules:       []AccountRule{{AccountNumber: 3027, ProductUUIDs: []string{"beer-uuid"}}},
+			useBarcodes: true,
+			product:     beer,
+			variant:     beer.Variants[1],
+			want:        3027,
+		},
+		{
+			name:        "barcode",
+			useBarcodes: true,
+			product:     beer,
+			variant:     beer.Variants[1],
+			want:        3012,
+		},
+		{
+			name:    "barcodes not used",
+			product: beer,
+			variant: beer.Variants[1],
+			want:    0,
+		},
+		{
+			name:        "barcode which is not a number",
+			useBarcodes: true,
+			product:     sandwich,
+			variant:     sandwich.Variants[0],
+			want:        0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := NewAccountResolver(test.rules, test.useBarcodes, 3010)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := r.Account(test.product, test.variant)
+			if got != test.want {
+				t.Errorf("expected %d, got %d", test.want, got)
+			}
+		})
+	}
+}
+
+func TestNewAccountResolver(t *testing.T) {
+	tests := []struct {
+		name string
+		rule AccountRule
+	}{
+		{
+			name: "without an account",
+			rule: AccountRule{Categories: []string{"Pub"}},
+		},
+		{
+			name: "matches everything",
+			rule: AccountRule{AccountNumber: 3010},
+		},
+		{
+			name: "invalid regex",
+			rule: AccountRule{AccountNumber: 3010, NameRegex: "Beer ("},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewAccountResolver([]AccountRule{test.rule}, false, 3010)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestUnresolved(t *testing.T) {
+	r, err := NewAccountResolver([]AccountRule{{AccountNumber: 3020, VariantUUIDs: []string{"small-uuid"}}}, true, 3010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beerWithoutBarcode := beer
+	beerWithoutBarcode.Variants = []Variant{beer.Variants[0], {UUID: "keg-uuid", Name: "Keg"}}
+	got := r.Unresolved([]Product{beerWithoutBarcode, sandwich})
+	want := []string{
+		`Beer, Keg (variant keg-uuid, barcode "")`,
+		`Sandwich (variant sandwich-variant-uuid, barcode "7310865004703a")`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	if r.DefaultAccount() != 3010 {
+		t.Errorf("expected the default account 3010, got %d", r.DefaultAccount())
+	}
+}
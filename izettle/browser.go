@@ -3,6 +3,7 @@ package izettle
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/chromedp/cdproto/network"
@@ -10,44 +11,106 @@ import (
 
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type BrowersClient struct {
 	httpClient *http.Client
 }
 
-func BrowserLoginEmail(email, password string) (*BrowersClient, string, error) {
+// ErrTwoFactor is returned when iZettle asks for a second factor,
+// which can only be given in a visible browser.
+var ErrTwoFactor = errors.New("iZettle asked for two-factor authentication, log in with a visible browser to complete it")
+
+// ErrCaptcha is returned when iZettle shows a captcha,
+// which can only be solved in a visible browser.
+var ErrCaptcha = errors.New("iZettle showed a captcha, log in with a visible browser to solve it")
+
+// Session is the _izsessionat cookie which the browser client uses
+type Session struct {
+	Cookie string
+	// Expires is zero if the cookie does not have an expiry date
+	Expires time.Time
+}
+
+// ExpiresWithin returns true if the session expires within the duration
+func (s Session) ExpiresWithin(d time.Duration) bool {
+	return !s.Expires.IsZero() && time.Now().Add(d).After(s.Expires)
+}
+
+// loginState is evaluated on the page after the password is submitted, to
+// tell when the login is done or needs something we can not give headless.
+const loginState = `(function() {
+	if (document.querySelector('.dashboard')) return 'dashboard';
+	if (document.querySelector('iframe[src*="recaptcha"], iframe[src*="hcaptcha"], .g-recaptcha, .h-captcha')) return 'captcha';
+	if (document.querySelector('input[autocomplete="one-time-code"]') || /two-factor|2fa|otp/i.test(location.href)) return 'two-factor';
+	return '';
+})()`
+
+// BrowserLoginEmail logs in with a Chrome browser. A headless browser fails
+// with ErrTwoFactor or ErrCaptcha if iZettle asks for more than the password,
+// in a visible browser the user is given a few minutes to complete the login.
+func BrowserLoginEmail(email, password string, headless bool) (*BrowersClient, *Session, error) {
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("headless", false),
+		chromedp.Flag("headless", headless),
 	)
 	allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
 	defer cancel()
 
-	taskCtx, cancel := chromedp.NewContext(allocCtx)
+	timeout := time.Minute
+	if !headless {
+		timeout = 5 * time.Minute
+	}
+	timeoutCtx, cancel := context.WithTimeout(allocCtx, timeout)
+	defer cancel()
+	taskCtx, cancel := chromedp.NewContext(timeoutCtx)
 	defer cancel()
 
-	session := ""
-	loginURL := fmt.Sprintf("https://login.izettle.com/login?username=%s", email)
+	session := &Session{}
+	loginURL := fmt.Sprintf("https://login.izettle.com/login?username=%s", url.QueryEscape(email))
 	tasks := chromedp.Tasks{
 		chromedp.Navigate(loginURL),
 		chromedp.WaitVisible(`#password`, chromedp.ByID),
 		chromedp.SendKeys(`#password`, password, chromedp.ByID),
 		chromedp.Click("#submitBtn"),
-		chromedp.WaitVisible(`.dashboard`, chromedp.ByQuery),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			for {
+				state := ""
+				err := chromedp.Evaluate(loginState, &state).Do(ctx)
+				if err != nil {
+					return err
+				}
+				switch {
+				case state == "dashboard":
+					return nil
+				case state == "two-factor" && headless:
+					return ErrTwoFactor
+				case state == "captcha" && headless:
+					return ErrCaptcha
+				}
+				select {
+				case <-ctx.Done():
+					return fmt.Errorf("timed out waiting for the iZettle dashboard after logging in")
+				case <-time.After(time.Second):
+				}
+			}
+		}),
 		chromedp.ActionFunc(func(ctx context.Context) error {
 			cookies, err := network.GetAllCookies().Do(ctx)
 			if err != nil {
 				return err
 			}
-			for i, cookie := range cookies {
-				log.Printf("chrome cookie %d: %+v", i, cookie)
+			for _, cookie := range cookies {
 				if cookie.Name == "_izsessionat" {
-					session = cookie.Value
+					session.Cookie = cookie.Value
+					// Session cookies have no expiry and are represented as -1
+					if cookie.Expires > 0 {
+						session.Expires = time.Unix(int64(cookie.Expires), 0)
+					}
 					return nil
 				}
 			}
@@ -57,10 +120,10 @@ func BrowserLoginEmail(email, password string) (*BrowersClient, string, error) {
 
 	// ensure that the browser process is started
 	if err := chromedp.Run(taskCtx, tasks); err != nil {
-		return nil, "", err
+		return nil, nil, err
 	}
 
-	return BrowserLoginCookie(session), session, nil
+	return BrowserLoginCookie(session.Cookie), session, nil
 }
 
 func BrowserLoginCookie(cookie string) *BrowersClient {
@@ -103,6 +166,12 @@ func (i *BrowersClient) IsLoggedIn() bool {
 	return true
 }
 
+// ErrNotLoggedIn is returned when my.izettle.com wants us to log in again
+var ErrNotLoggedIn = errors.New("the iZettle browser session has expired")
+
+// DayReportToPDF downloads the daily report of the user. The response is
+// verified to be a PDF, since an expired session gives us the login page
+// or an unauthorized status.
 func (i *BrowersClient) DayReportToPDF(report Report) (io.Reader, error) {
 	date := report.Date.Time().Format("2006-01-02")
 	pdfURL := fmt.Sprintf("https://my.izettle.com/reports.pdf?user=%d&aggregation=day&date=%s&type=pdf", report.UserID, date)
@@ -115,5 +184,27 @@ func (i *BrowersClient) DayReportToPDF(report Report) (io.Reader, error) {
 	if err != nil {
 		return nil, err
 	}
+	if resp.Request.URL.Host == "login.izettle.com" ||
+		resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, ErrNotLoggedIn
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("the report PDF of %s %s could not be downloaded, got '%s'", report.Username, date, resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if strings.Contains(contentType, "text/html") && bytes.Contains(body, []byte("login.izettle.com")) {
+		return nil, ErrNotLoggedIn
+	}
+	if !strings.HasPrefix(contentType, "application/pdf") || !bytes.HasPrefix(body, []byte("%PDF")) {
+		return nil, fmt.Errorf("the report of %s %s is not a PDF, got '%s' starting with %q", report.Username, date, contentType, preview(body))
+	}
 	return bytes.NewReader(body), nil
 }
+
+// preview returns the start of the body, to tell what we got instead of a PDF
+func preview(body []byte) string {
+	if len(body) > 40 {
+		return string(body[:40]) + "..."
+	}
+	return string(body)
+}
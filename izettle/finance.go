@@ -0,0 +1,71 @@
+package izettle
+
+import (
+	"encoding/json"
+	"fmt"
+	"izettle-daily-reports/util"
+	"net/url"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// LiquidAccount is the account type group of the finance API which holds
+// money which is ready to be paid out.
+const LiquidAccount = "LIQUID"
+
+// Transaction types of the finance API
+const CardPaymentFee = "CARD_PAYMENT_FEE"
+const CardPaymentFeeRefund = "CARD_PAYMENT_FEE_REFUND"
+const Payout = "PAYOUT"
+
+// transactionPageSize is the largest page size allowed by the finance API
+const transactionPageSize = 1000
+
+type Transaction struct {
+	Timestamp                  util.Date  `json:"timestamp"`
+	Amount                     util.Money `json:"amount"`
+	OriginatorTransactionType  string     `json:"originatorTransactionType"`
+	OriginatingTransactionUUID string     `json:"originatingTransactionUuid"`
+}
+
+// Transactions lists the transactions of an account type group between two
+// dates, both dates included. If any transaction types are given only
+// transactions of those types are returned.
+func (c *Client) Transactions(from util.Date, to util.Date, accountTypeGroup string, transactionTypes ...string) ([]Transaction, error) {
+	query := url.Values{}
+	query.Set("start", from.Time().Format("2006-01-02T15:04:05Z07:00"))
+	// The end of the interval is exclusive
+	query.Set("end", to.Time().AddDate(0, 0, 1).Format("2006-01-02T15:04:05Z07:00"))
+	for _, t := range transactionTypes {
+		query.Add("includeTransactionType", t)
+	}
+	query.Set("limit", fmt.Sprint(transactionPageSize))
+
+	transactions := []Transaction{}
+	// The finance API pages by offset instead of by links
+	for offset := 0; ; offset += transactionPageSize {
+		query.Set("offset", fmt.Sprint(offset))
+		data, err := c.GetRequest(fmt.Sprintf("%s/v2/accounts/%s/transactions?%s", financeURL, accountTypeGroup, query.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		resp := struct {
+			Data []Transaction
+		}{}
+		err = json.Unmarshal(data, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range resp.Data {
+			// We divide the amount by 100 since an amount of 100.00 is
+			// represented as 10000.
+			t.Amount = util.Money{Decimal: t.Amount.Div(decimal.NewFromInt(100))}
+			transactions = append(transactions, t)
+		}
+		if len(resp.Data) < transactionPageSize {
+			return transactions, nil
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 const productURL = "https://products.izettle.com"
 const purchaseURL = "https://purchase.izettle.com"
+const financeURL = "https://finance.izettle.com"
 const oauthURL = "https://oauth.izettle.com"
 
 type Client struct {
@@ -1,58 +1,106 @@
 package izettle
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"izettle-daily-reports/loopback"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 )
 
+// Endpoint wants the client id and secret as parameters, the auto detection
+// of the oauth2 package does not always fall back to them.
 var Endpoint = oauth2.Endpoint{
-	AuthURL:  "https://oauth.izettle.com/authorize",
-	TokenURL: "https://oauth.izettle.com/token",
+	AuthURL:   "https://oauth.izettle.com/authorize",
+	TokenURL:  "https://oauth.izettle.com/token",
+	AuthStyle: oauth2.AuthStyleInParams,
 }
 
-func Login(user, password, id, secret string) (*Client, error) {
-	//storage := &loopback.Storage{Name: "izettle"}
-	oauth := &oauth2.Config{
-		ClientID:     id,
-		ClientSecret: secret,
-		Endpoint:     Endpoint,
+// assertionGrant is the grant type of the API keys made at
+// https://my.izettle.com/apps/api-keys
+const assertionGrant = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// Login uses a password grant, and saves the token in the store so that
+// later logins only refresh it.
+//
+// Deprecated: the password grant is deprecated by iZettle, use LoginAPIKey.
+func Login(user, password, id, secret string, store loopback.TokenStore) (*Client, error) {
+	auth := &loopback.Auth{
+		Storage: store,
+		Oauth: &oauth2.Config{
+			ClientID:     id,
+			ClientSecret: secret,
+			Endpoint:     Endpoint,
+		},
+	}
+	token, err := auth.Storage.Load()
+	if err == nil {
+		source, err := auth.Refresh(token)
+		if err == nil {
+			return &Client{token: source}, nil
+		}
+		// The refresh token has expired, log in with the password again
+	}
+	token, err = fetchToken(url.Values{
+		"grant_type":    {"password"},
+		"client_id":     {id},
+		"client_secret": {secret},
+		"username":      {user},
+		"password":      {password},
+	})
+	if err != nil {
+		return nil, err
 	}
-	//auth := &loopback.Auth{
-	//	Storage: storage,
-	//	Oauth:   oauth,
-	//}
+	err = auth.Persist(*token)
+	if err != nil {
+		return nil, fmt.Errorf("unable to save the token: %s", err)
+	}
+	return &Client{token: auth.TokenSource(token)}, nil
+}
 
-	// Refreshi is currently not working
-	//token, err := storage.Load()
-	//if err == nil {
-	//	token, err := auth.Refresh(token)
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//	return &Client{token: token}, nil
-	//}
-	token, err := fetchToken(user, password, id, secret)
+// LoginAPIKey uses an assertion grant with an API key, which does not give
+// a refresh token. A new token is fetched with the API key when it expires.
+func LoginAPIKey(id, apiKey string) (*Client, error) {
+	source := assertionSource{id: id, apiKey: apiKey}
+	token, err := source.Token()
 	if err != nil {
 		return nil, err
 	}
-	//_ = storage.Persist(*token)
-	return &Client{token: oauth.TokenSource(context.Background(), token)}, nil
+	return &Client{token: oauth2.ReuseTokenSource(token, source)}, nil
 }
 
-// fetchToken uses a password grant instead of an ordinary oauth
+type assertionSource struct {
+	id     string
+	apiKey string
+}
+
+func (s assertionSource) Token() (*oauth2.Token, error) {
+	return fetchToken(url.Values{
+		"grant_type": {assertionGrant},
+		"client_id":  {s.id},
+		"assertion":  {s.apiKey},
+	})
+}
+
+// tokenResponse is the token as returned by iZettle, which has the
+// lifetime of the token instead of its expiry.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// fetchToken requests a token directly instead of with an ordinary oauth
 // login since this is a private integration
 // https://github.com/iZettle/api-documentation/blob/master/authorization.adoc
-func fetchToken(user, password, id, secret string) (*oauth2.Token, error) {
-	bodyStr := fmt.Sprintf("grant_type=password&client_id=%s&client_secret=%s&username=%s&password=%s", id, secret, user, password)
-	body := bytes.NewReader([]byte(url.PathEscape(bodyStr)))
-	resp, err := http.Post(oauthURL+"/token", "application/x-www-form-urlencoded", body)
+func fetchToken(form url.Values) (*oauth2.Token, error) {
+	resp, err := http.Post(oauthURL+"/token", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -62,11 +110,22 @@ func fetchToken(user, password, id, secret string) (*oauth2.Token, error) {
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("iZettle login failed: %s. Got '%s'", string(bytes), resp.Status)
+	}
 
-	token := &oauth2.Token{}
-	err = json.Unmarshal(bytes, token)
+	tokenResp := &tokenResponse{}
+	err = json.Unmarshal(bytes, tokenResp)
 	if err != nil {
 		return nil, err
 	}
+	token := &oauth2.Token{
+		AccessToken:  tokenResp.AccessToken,
+		TokenType:    tokenResp.TokenType,
+		RefreshToken: tokenResp.RefreshToken,
+	}
+	if tokenResp.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	}
 	return token, nil
 }
@@ -0,0 +1,89 @@
+package izettle
+
+import (
+	"izettle-daily-reports/util"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PayoutReport is a payout from iZettle to the bank together with the card
+// fees which were withheld since the previous payout.
+type PayoutReport struct {
+	Date   util.Date
+	UUID   string
+	Amount util.Money
+	Fees   util.Money
+}
+
+// Sum is the amount cleared from the iZettle ledger account by the payout
+func (r PayoutReport) Sum() util.Money {
+	return util.Money{Decimal: r.Amount.Add(r.Fees.Decimal)}
+}
+
+// PayoutReports groups the fees of the transactions by the payout they were
+// withheld from. The first payout is left out together with the fees before
+// it, since its fees may have been withheld before the first transaction.
+// Fees after the last payout are not included.
+func PayoutReports(transactions []Transaction, timeZone *time.Location) []PayoutReport {
+	sorted := make([]Transaction, len(transactions))
+	copy(sorted, transactions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	reports := []PayoutReport{}
+	fees := decimal.Zero
+	first := true
+	for _, t := range sorted {
+		switch t.OriginatorTransactionType {
+		case CardPaymentFee, CardPaymentFeeRefund:
+			// Fees are withdrawn from the account and are therefore negative
+			fees = fees.Sub(t.Amount.Decimal)
+		case Payout:
+			if first {
+				first = false
+				fees = decimal.Zero
+				continue
+			}
+			dateTime := t.Timestamp.Time().In(timeZone)
+			date := time.Date(dateTime.Year(), dateTime.Month(), dateTime.Day(), 0, 0, 0, 0, time.UTC)
+			reports = append(reports, PayoutReport{
+				Date:   util.DateFromTime(date),
+				UUID:   t.OriginatingTransactionUUID,
+				Amount: util.Money{Decimal: t.Amount.Abs()},
+				Fees:   util.Money{Decimal: fees},
+			})
+			fees = decimal.Zero
+		}
+	}
+	return reports
+}
+
+// PayoutTransactions returns the payouts and fees between the dates, starting
+// at the last payout before the from date so that PayoutReports has every
+// fee of the payouts in the range. It looks at most a year back.
+func (c *Client) PayoutTransactions(from util.Date, to util.Date) ([]Transaction, error) {
+	transactions, err := c.Transactions(from, to, LiquidAccount, Payout, CardPaymentFee, CardPaymentFeeRefund)
+	if err != nil {
+		return nil, err
+	}
+	end := from.Time()
+	for i := 0; i < 12; i++ {
+		start := end.AddDate(0, -1, 0)
+		earlier, err := c.Transactions(util.DateFromTime(start), util.DateFromTime(end.AddDate(0, 0, -1)),
+			LiquidAccount, Payout, CardPaymentFee, CardPaymentFeeRefund)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(earlier, transactions...)
+		for _, t := range earlier {
+			if t.OriginatorTransactionType == Payout {
+				return transactions, nil
+			}
+		}
+		end = start
+	}
+	return transactions, nil
+}
@@ -0,0 +1,81 @@
+package izettle
+
+import (
+	"izettle-daily-reports/util"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func money(s string) util.Money {
+	return util.Money{Decimal: decimal.RequireFromString(s)}
+}
+
+func transaction(day int, transactionType string, amount string) Transaction {
+	return Transaction{
+		Timestamp:                  util.DateFromTime(time.Date(2020, 10, day, 12, 0, 0, 0, time.UTC)),
+		Amount:                     money(amount),
+		OriginatorTransactionType:  transactionType,
+		OriginatingTransactionUUID: "payout-" + transactionType + "-" + amount,
+	}
+}
+
+func TestPayoutReports(t *testing.T) {
+	tests := []struct {
+		name         string
+		transactions []Transaction
+		want         []PayoutReport
+	}{
+		{
+			name:         "no payouts",
+			transactions: []Transaction{transaction(1, CardPaymentFee, "-1")},
+			want:         []PayoutReport{},
+		},
+		{
+			name: "the first payout is left out",
+			transactions: []Transaction{
+				transaction(1, CardPaymentFee, "-1"),
+				transaction(2, Payout, "-100"),
+			},
+			want: []PayoutReport{},
+		},
+		{
+			name: "fees since the previous payout",
+			transactions: []Transaction{
+				// Not sorted, like the pages of the finance API
+				transaction(5, Payout, "-200"),
+				transaction(1, CardPaymentFee, "-1"),
+				transaction(2, Payout, "-100"),
+				transaction(3, CardPaymentFee, "-2"),
+				transaction(4, CardPaymentFeeRefund, "0.5"),
+				transaction(6, CardPaymentFee, "-3"),
+			},
+			want: []PayoutReport{
+				{Date: util.DateFromStringOrPanic("2020-10-05"), UUID: "payout-PAYOUT--200", Amount: money("200"), Fees: money("1.5")},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := PayoutReports(test.transactions, time.UTC)
+			if len(got) != len(test.want) {
+				t.Fatalf("expected %d payouts, got %d", len(test.want), len(got))
+			}
+			for i, want := range test.want {
+				if !got[i].Date.Equal(want.Date) || got[i].UUID != want.UUID ||
+					!got[i].Amount.Equal(want.Amount.Decimal) || !got[i].Fees.Equal(want.Fees.Decimal) {
+					t.Errorf("expected %s %s %s %s, got %s %s %s %s", want.Date.String(), want.UUID, want.Amount, want.Fees,
+						got[i].Date.String(), got[i].UUID, got[i].Amount, got[i].Fees)
+				}
+			}
+		})
+	}
+}
+
+func TestPayoutReportSum(t *testing.T) {
+	report := PayoutReport{Amount: money("200"), Fees: money("1.5")}
+	if !report.Sum().Equal(decimal.RequireFromString("201.5")) {
+		t.Errorf("expected 201.5, got %s", report.Sum())
+	}
+}
@@ -108,15 +108,22 @@ func (c *Client) Purchases(from util.Date, to util.Date) (*Purchases, error) {
 		return nil, err
 	}
 
+	// All purchases made during the to date are included
+	year, month, day = to.Time().Date()
+	end := util.DateFromTime(time.Date(year, month, day+1, 0, 0, 0, 0, to.Time().Location()))
 	filteredPurchases := []Purchase{}
 	for _, p := range purchases {
-		if !p.Timestamp.Before(from) && !p.Timestamp.After(to) {
+		if !p.Timestamp.Before(from) && p.Timestamp.Before(end) {
 			purchase := p
 			for i, prod := range purchase.Products {
 				// We divide the price by 100 since a price of 100.00 is represented as
 				// 10000.
 				priceDividedBy100 := prod.UnitPrice.Div(decimal.NewFromInt(100))
-				purchase.Products[i].UnitPrice = util.Money{priceDividedBy100}
+				purchase.Products[i].UnitPrice = util.Money{Decimal: priceDividedBy100}
+			}
+			for i, payment := range purchase.Payments {
+				amountDividedBy100 := payment.Amount.Div(decimal.NewFromInt(100))
+				purchase.Payments[i].Amount = util.Money{Decimal: amountDividedBy100}
 			}
 			filteredPurchases = append(filteredPurchases, purchase)
 		}
@@ -126,9 +133,11 @@ func (c *Client) Purchases(from util.Date, to util.Date) (*Purchases, error) {
 }
 
 type PurchaseSummary struct {
-	Product *PurchaseProduct
-	Count   int
-	Amount  util.Money
+	Product      *PurchaseProduct
+	Count        int
+	Amount       util.Money
+	Refund       bool
+	CashRegister CashRegister
 }
 
 type PurchaseSummaries struct {
@@ -142,10 +151,24 @@ func (r PurchaseSummaries) Summary() PurchaseSummary {
 		d = d.Add(p.Amount.Decimal)
 		s.Count += p.Count
 	}
-	s.Amount = util.Money{d}
+	s.Amount = util.Money{Decimal: d}
 	return s
 }
 
+// Group splits the summaries into groups which can be booked on a single
+// row. The same variant can be sold with different VAT rates or on different
+// cash registers, and refunds are always kept apart from sales.
+func (r PurchaseSummaries) Group() map[string]PurchaseSummaries {
+	groups := make(map[string]PurchaseSummaries)
+	for _, p := range r.Purchase {
+		key := fmt.Sprintf("%s/%t/%s", p.Product.VatPercentage.String(), p.Refund, p.CashRegister.UUID)
+		g := groups[key]
+		g.Purchase = append(g.Purchase, p)
+		groups[key] = g
+	}
+	return groups
+}
+
 type GroupedPurchases struct {
 	Date      util.Date
 	User      int
@@ -162,11 +185,22 @@ func (s GroupedPurchases) Summary() map[string]PurchaseSummaries {
 			if err != nil {
 				panic(err)
 			}
+			amount := product.UnitPrice.Mul(decimal.NewFromInt(int64(count)))
+			if purchase.Refund {
+				// Refunds are always negative, no matter how iZettle chose
+				// to represent the refunded quantity.
+				amount = amount.Abs().Neg()
+				if count < 0 {
+					count = -count
+				}
+			}
 			v := variants[product.VariantUUID]
 			v.Purchase = append(v.Purchase, PurchaseSummary{
-				Product: &product,
-				Count:   count,
-				Amount:  util.Money{product.UnitPrice.Mul(decimal.NewFromInt(int64(count)))},
+				Product:      &product,
+				Count:        count,
+				Amount:       util.Money{Decimal: amount},
+				Refund:       purchase.Refund,
+				CashRegister: purchase.CashRegister,
 			})
 			variants[product.VariantUUID] = v
 		}
@@ -174,6 +208,39 @@ func (s GroupedPurchases) Summary() map[string]PurchaseSummaries {
 	return variants
 }
 
+// PaymentSummary sums the payments of the group by payment type,
+// e.g. IZETTLE_CARD, IZETTLE_CASH or SWISH.
+func (s GroupedPurchases) PaymentSummary() map[string]util.Money {
+	payments := make(map[string]util.Money)
+	for _, purchase := range s.purchases.Purchases {
+		for _, payment := range purchase.Payments {
+			amount := payment.Amount.Decimal
+			if purchase.Refund {
+				amount = amount.Abs().Neg()
+			}
+			sum := payments[payment.Type]
+			payments[payment.Type] = util.Money{Decimal: sum.Add(amount)}
+		}
+	}
+	return payments
+}
+
+// PurchaseCount is the number of purchases in the group which are not refunds
+func (s GroupedPurchases) PurchaseCount() int {
+	return len(s.purchases.Purchases) - s.RefundCount()
+}
+
+// RefundCount is the number of refund purchases in the group
+func (s GroupedPurchases) RefundCount() int {
+	count := 0
+	for _, purchase := range s.purchases.Purchases {
+		if purchase.Refund {
+			count++
+		}
+	}
+	return count
+}
+
 func (p Purchases) GroupByDate(timeZone *time.Location) map[string]Purchases {
 	dates := make(map[string]Purchases)
 	for _, dp := range p.Purchases {
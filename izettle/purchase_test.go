@@ -0,0 +1,128 @@
+package izettle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func refundAt(uuid string, timestamp string, user int, products ...PurchaseProduct) Purchase {
+	purchase := purchaseAt(uuid, timestamp, user, products...)
+	purchase.Refund = true
+	return purchase
+}
+
+func TestSummaryRefunds(t *testing.T) {
+	tests := []struct {
+		name      string
+		purchases []Purchase
+		want      []string
+	}{
+		{
+			name: "sales",
+			purchases: []Purchase{
+				purchaseAt("1", "2020-10-13T18:00:00Z", 42, purchaseProduct("beer-large", "2", "25", "62.5")),
+			},
+			want: []string{"2 125.00"},
+		},
+		{
+			name: "refunds with a positive quantity",
+			purchases: []Purchase{
+				refundAt("1", "2020-10-13T18:00:00Z", 42, purchaseProduct("beer-large", "2", "25", "62.5")),
+			},
+			want: []string{"2 -125.00 refund"},
+		},
+		{
+			name: "refunds with a negative quantity",
+			purchases: []Purchase{
+				refundAt("1", "2020-10-13T18:00:00Z", 42, purchaseProduct("beer-large", "-2", "25", "62.5")),
+			},
+			want: []string{"2 -125.00 refund"},
+		},
+		{
+			name: "refunds are kept apart from the sales",
+			purchases: []Purchase{
+				purchaseAt("1", "2020-10-13T18:00:00Z", 42, purchaseProduct("beer-large", "3", "25", "62.5")),
+				refundAt("2", "2020-10-13T19:00:00Z", 42, purchaseProduct("beer-large", "1", "25", "62.5")),
+			},
+			want: []string{"1 -62.50 refund", "3 187.50"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grouped := GroupedPurchases{purchases: Purchases{Purchases: test.purchases}}
+			got := []string{}
+			for _, groups := range grouped.Summary() {
+				for _, g := range groups.Group() {
+					s := g.Summary()
+					line := fmt.Sprintf("%d %s", s.Count, s.Amount.StringFixed(2))
+					if g.Purchase[0].Refund {
+						line += " refund"
+					}
+					got = append(got, line)
+				}
+			}
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func TestReportsRefunds(t *testing.T) {
+	resolver, err := NewAccountResolver(nil, true, 3110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	purchases := Purchases{Purchases: []Purchase{
+		purchaseAt("1", "2020-10-13T18:00:00Z", 42, purchaseProduct("beer-large", "2", "25", "62.5")),
+		refundAt("2", "2020-10-13T19:00:00Z", 42, purchaseProduct("beer-large", "-1", "25", "62.5")),
+	}}
+	reports := Reports(purchases, testProducts, resolver, time.UTC)
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reports))
+	}
+	report := reports[0]
+	want := []string{
+		"1x Beer, Large 3010 25% -62.50 refund",
+		"2x Beer, Large 3010 25% 125.00",
+	}
+	got := reportRowStrings(report)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	if report.PurchaseCount != 1 || report.RefundCount != 1 {
+		t.Errorf("expected 1 purchase and 1 refund, got %d and %d", report.PurchaseCount, report.RefundCount)
+	}
+	if !report.Sum().Equal(money("62.5").Decimal) || !report.Refunds().Equal(money("-62.5").Decimal) {
+		t.Errorf("expected the sum 62.5 and the refunds -62.5, got %s and %s", report.Sum(), report.Refunds())
+	}
+}
+
+func TestPaymentSummary(t *testing.T) {
+	withPayments := func(purchase Purchase, payments ...Payment) Purchase {
+		purchase.Payments = payments
+		return purchase
+	}
+	grouped := GroupedPurchases{purchases: Purchases{Purchases: []Purchase{
+		withPayments(purchaseAt("1", "2020-10-13T18:00:00Z", 42), Payment{Type: "IZETTLE_CARD", Amount: money("100")}),
+		withPayments(purchaseAt("2", "2020-10-13T18:10:00Z", 42),
+			Payment{Type: "IZETTLE_CARD", Amount: money("20")}, Payment{Type: "IZETTLE_CASH", Amount: money("50")}),
+		// Refunds are negative no matter how iZettle represents them
+		withPayments(refundAt("3", "2020-10-13T18:20:00Z", 42), Payment{Type: "IZETTLE_CASH", Amount: money("30")}),
+		withPayments(refundAt("4", "2020-10-13T18:30:00Z", 42), Payment{Type: "SWISH", Amount: money("-10")}),
+	}}}
+	want := map[string]string{"IZETTLE_CARD": "120", "IZETTLE_CASH": "20", "SWISH": "-10"}
+	got := grouped.PaymentSummary()
+	if len(got) != len(want) {
+		t.Errorf("expected %d payment types, got %d", len(want), len(got))
+	}
+	for paymentType, amount := range want {
+		if !got[paymentType].Equal(money(amount).Decimal) {
+			t.Errorf("expected %s %s, got %s", paymentType, amount, got[paymentType])
+		}
+	}
+}
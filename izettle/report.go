@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"izettle-daily-reports/util"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -12,23 +11,54 @@ import (
 )
 
 type Report struct {
-	Date        util.Date
-	UserID      int
-	Username    string
-	Rows        []ReportRow
-	Attachments [][]byte
+	Date     util.Date
+	UserID   int
+	Username string
+	Rows     []ReportRow
+	Payments []PaymentRow
+	// PurchaseCount is the number of sales, not including refunds
+	PurchaseCount int
+	RefundCount   int
+	Attachments   [][]byte
 }
 
+// PaymentRow is the sum of all payments of the same type, refunded
+// payments are negative.
+type PaymentRow struct {
+	Type   string
+	Amount util.Money
+}
+
+// ReportRow is the sum of the sold or refunded items of a product variant.
+// Refunded rows have a negative amount but a positive count.
 type ReportRow struct {
-	Name         string
-	Count        int
-	Amount       util.Money
-	VismaAccount int
+	Name          string
+	Count         int
+	Amount        util.Money
+	VatPercentage util.Money
+	VismaAccount  int
+	Refund        bool
+	// ProductUUID and Category are empty for custom products
+	ProductUUID  string
+	Category     Category
+	CashRegister CashRegister
 }
 
+// VismaRow is the sum of all report rows sharing the same visma account,
+// VAT percentage and refund status. Amount includes VAT.
 type VismaRow struct {
-	Amount       util.Money
-	VismaAccount int
+	Amount        util.Money
+	VatPercentage util.Money
+	VatAmount     util.Money
+	VismaAccount  int
+	Refund        bool
+	// Rows are the report rows which are summed up
+	Rows []ReportRow
+}
+
+// NetAmount is the amount of the row excluding VAT
+func (r VismaRow) NetAmount() util.Money {
+	return util.Money{Decimal: r.Amount.Sub(r.VatAmount.Decimal)}
 }
 
 func (r Report) Sum() util.Money {
@@ -36,27 +66,104 @@ func (r Report) Sum() util.Money {
 	for _, p := range r.Rows {
 		d = d.Add(p.Amount.Decimal)
 	}
-	return util.Money{d}
+	return util.Money{Decimal: d}
+}
+
+// Refunds is the sum of all refunded rows, it is always zero or negative
+func (r Report) Refunds() util.Money {
+	d := decimal.Zero
+	for _, p := range r.Rows {
+		if p.Refund {
+			d = d.Add(p.Amount.Decimal)
+		}
+	}
+	return util.Money{Decimal: d}
+}
+
+// VatRow is the sum of all rows with the same VAT percentage,
+// Amount includes VAT.
+type VatRow struct {
+	VatPercentage util.Money
+	Amount        util.Money
+	VatAmount     util.Money
+}
+
+// NetAmount is the amount of the row excluding VAT
+func (r VatRow) NetAmount() util.Money {
+	return util.Money{Decimal: r.Amount.Sub(r.VatAmount.Decimal)}
+}
+
+// VatRows returns the VAT breakdown of the report, sorted by VAT percentage
+func (r Report) VatRows() []VatRow {
+	vats := make(map[string]VatRow)
+	for _, row := range r.Rows {
+		key := row.VatPercentage.String()
+		vat := vats[key]
+		vat.VatPercentage = row.VatPercentage
+		vat.Amount = util.Money{Decimal: vat.Amount.Add(row.Amount.Decimal)}
+		vats[key] = vat
+	}
+	vatList := make([]VatRow, 0)
+	for _, v := range vats {
+		v.VatAmount = vatFromGross(v.Amount, v.VatPercentage)
+		vatList = append(vatList, v)
+	}
+	sort.SliceStable(vatList, func(i, j int) bool {
+		return vatList[i].VatPercentage.LessThan(vatList[j].VatPercentage.Decimal)
+	})
+	return vatList
 }
 
 func (r Report) RowsByVismaAccount() ([]VismaRow, error) {
-	accounts := make(map[int]VismaRow)
+	type accountVat struct {
+		account int
+		vat     string
+		refund  bool
+	}
+	accounts := make(map[accountVat]VismaRow)
 	for _, row := range r.Rows {
 		if row.VismaAccount == 0 {
 			return nil, fmt.Errorf("row contained an item without a visma account: %s", row.Name)
 		}
-		account := accounts[row.VismaAccount]
+		key := accountVat{account: row.VismaAccount, vat: row.VatPercentage.String(), refund: row.Refund}
+		account := accounts[key]
 		account.VismaAccount = row.VismaAccount
-		account.Amount = util.Money{account.Amount.Add(row.Amount.Decimal)}
-		accounts[account.VismaAccount] = account
+		account.VatPercentage = row.VatPercentage
+		account.Refund = row.Refund
+		account.Amount = util.Money{Decimal: account.Amount.Add(row.Amount.Decimal)}
+		account.Rows = append(account.Rows, row)
+		accounts[key] = account
 	}
 	accountList := make([]VismaRow, 0)
 	for _, a := range accounts {
+		// The VAT is calculated on the sum of the rows instead of on each row
+		// to avoid accumulating rounding errors.
+		a.VatAmount = vatFromGross(a.Amount, a.VatPercentage)
 		accountList = append(accountList, a)
 	}
+	sort.SliceStable(accountList, func(i, j int) bool {
+		if accountList[i].VismaAccount != accountList[j].VismaAccount {
+			return accountList[i].VismaAccount < accountList[j].VismaAccount
+		}
+		if !accountList[i].VatPercentage.Equal(accountList[j].VatPercentage.Decimal) {
+			return accountList[i].VatPercentage.LessThan(accountList[j].VatPercentage.Decimal)
+		}
+		return !accountList[i].Refund && accountList[j].Refund
+	})
 	return accountList, nil
 }
 
+// vatFromGross returns the VAT part of an amount which includes VAT,
+// rounded to whole öre.
+func vatFromGross(amount util.Money, percentage util.Money) util.Money {
+	if percentage.IsZero() {
+		return util.Money{Decimal: decimal.Zero}
+	}
+	hundred := decimal.NewFromInt(100)
+	vat := amount.Mul(percentage.Decimal).Div(hundred.Add(percentage.Decimal))
+	return util.Money{Decimal: vat.Round(2)}
+}
+
 func findProductVariant(uuid string, products []Product) (Product, Variant, bool) {
 	for _, p := range products {
 		for _, v := range p.Variants {
@@ -68,7 +175,9 @@ func findProductVariant(uuid string, products []Product) (Product, Variant, bool
 	return Product{}, Variant{}, false
 }
 
-func Reports(purchases Purchases, products []Product, defaultAccountNumber int, timeZone *time.Location) []Report {
+// Reports groups the purchases into one report per user and day, the rows
+// are booked on the accounts given by the account resolver.
+func Reports(purchases Purchases, products []Product, accounts AccountResolver, timeZone *time.Location) []Report {
 	reports := []Report{}
 	purchaseUnits := purchases.Group(timeZone)
 	for _, purchase := range purchaseUnits {
@@ -76,41 +185,53 @@ func Reports(purchases Purchases, products []Product, defaultAccountNumber int,
 		purchaseVariants := purchase.Summary()
 		for variantUUID, pv := range purchaseVariants {
 			product, variant, found := findProductVariant(variantUUID, products)
-			if found {
-				var name string
-				if variant.Name == "" {
-					name = product.Name
+			for _, vv := range pv.Group() {
+				s := vv.Summary()
+				vat := vv.Purchase[0].Product.VatPercentage
+				refund := vv.Purchase[0].Refund
+				cashRegister := vv.Purchase[0].CashRegister
+				if found {
+					rows = append(rows, ReportRow{
+						Name:          variantName(product, variant),
+						Count:         s.Count,
+						Amount:        s.Amount,
+						VatPercentage: vat,
+						VismaAccount:  accounts.Account(product, variant),
+						Refund:        refund,
+						ProductUUID:   product.UUID,
+						Category:      product.Category,
+						CashRegister:  cashRegister,
+					})
 				} else {
-					name = product.Name + ", " + variant.Name
+					name := "Custom product"
+					rows = append(rows, ReportRow{
+						Name:          name,
+						Count:         s.Count,
+						Amount:        s.Amount,
+						VatPercentage: vat,
+						VismaAccount:  accounts.DefaultAccount(),
+						Refund:        refund,
+						CashRegister:  cashRegister,
+					})
 				}
-				barcode, _ := strconv.Atoi(variant.Barcode)
-				s := pv.Summary()
-				rows = append(rows, ReportRow{
-					Name:         name,
-					Count:        s.Count,
-					Amount:       s.Amount,
-					VismaAccount: barcode,
-				})
-			} else {
-				name := "Custom product"
-				s := pv.Summary()
-				rows = append(rows, ReportRow{
-					Name:         name,
-					Count:        s.Count,
-					Amount:       s.Amount,
-					VismaAccount: defaultAccountNumber,
-				})
 			}
 		}
-		if purchase.Date.Equal(util.DateFromStringOrPanic("2020-10-13")) {
-			fmt.Println("")
-		}
 		userName := strings.TrimSpace(strings.Split(purchase.Username, ".")[0])
+		payments := []PaymentRow{}
+		for paymentType, amount := range purchase.PaymentSummary() {
+			payments = append(payments, PaymentRow{Type: paymentType, Amount: amount})
+		}
+		sort.Slice(payments, func(i, j int) bool {
+			return payments[i].Type < payments[j].Type
+		})
 		reports = append(reports, Report{
-			Date:     purchase.Date,
-			UserID:   purchase.User,
-			Username: userName,
-			Rows:     rows,
+			Date:          purchase.Date,
+			UserID:        purchase.User,
+			Username:      userName,
+			Rows:          rows,
+			Payments:      payments,
+			PurchaseCount: purchase.PurchaseCount(),
+			RefundCount:   purchase.RefundCount(),
 		})
 	}
 
@@ -0,0 +1,197 @@
+package izettle
+
+import (
+	"fmt"
+	"izettle-daily-reports/util"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVatFromGross(t *testing.T) {
+	tests := []struct {
+		amount     string
+		percentage string
+		want       string
+	}{
+		{"125", "25", "25"},
+		{"112", "12", "12"},
+		{"106", "6", "6"},
+		{"10", "12", "1.07"},
+		{"33.33", "25", "6.67"},
+		{"0.99", "6", "0.06"},
+		{"-125", "25", "-25"},
+		{"100", "0", "0"},
+	}
+	for _, test := range tests {
+		t.Run(test.amount+"@"+test.percentage, func(t *testing.T) {
+			got := vatFromGross(money(test.amount), money(test.percentage))
+			if !got.Equal(money(test.want).Decimal) {
+				t.Errorf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func reportRow(name string, account int, vat string, amount string) ReportRow {
+	return ReportRow{Name: name, Count: 1, Amount: money(amount), VatPercentage: money(vat), VismaAccount: account}
+}
+
+// vismaRowString formats the row so that the rows can be compared in tests
+func vismaRowString(r VismaRow) string {
+	s := fmt.Sprintf("%d %s%% %s vat %s", r.VismaAccount, r.VatPercentage, r.Amount.StringFixed(2), r.VatAmount.StringFixed(2))
+	if r.Refund {
+		s += " refund"
+	}
+	return s
+}
+
+func TestRowsByVismaAccount(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []ReportRow
+		want []string
+	}{
+		{
+			name: "one row per account and VAT rate",
+			rows: []ReportRow{
+				reportRow("Food", 3020, "12", "112"),
+				reportRow("Beer", 3010, "25", "125"),
+				reportRow("Snacks", 3010, "25", "50"),
+				reportRow("Book", 3010, "6", "106"),
+			},
+			want: []string{
+				"3010 6% 106.00 vat 6.00",
+				"3010 25% 175.00 vat 35.00",
+				"3020 12% 112.00 vat 12.00",
+			},
+		},
+		{
+			name: "the VAT is calculated on the sum of the rows",
+			rows: []ReportRow{
+				reportRow("Candy", 3010, "25", "0.99"),
+				reportRow("Candy", 3010, "25", "0.99"),
+				reportRow("Candy", 3010, "25", "0.99"),
+			},
+			// Rounding each row would give 0.60
+			want: []string{"3010 25% 2.97 vat 0.59"},
+		},
+		{
+			name: "no VAT",
+			rows: []ReportRow{reportRow("Deposit", 2890, "0", "10")},
+			want: []string{"2890 0% 10.00 vat 0.00"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := Report{Rows: test.rows}.RowsByVismaAccount()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, r := range rows {
+				got = append(got, vismaRowString(r))
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func TestRowsByVismaAccountWithoutAccount(t *testing.T) {
+	_, err := Report{Rows: []ReportRow{reportRow("Beer", 0, "25", "125")}}.RowsByVismaAccount()
+	if err == nil {
+		t.Error("expected a row without an account to fail")
+	}
+}
+
+func purchaseAt(uuid string, timestamp string, user int, products ...PurchaseProduct) Purchase {
+	at, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		panic(err)
+	}
+	return Purchase{
+		PurchaseUUID:    uuid,
+		Timestamp:       util.DateFromTime(at),
+		UserID:          user,
+		UserDisplayName: "ZIK .",
+		Products:        products,
+	}
+}
+
+func purchaseProduct(variant string, quantity string, vat string, unitPrice string) PurchaseProduct {
+	return PurchaseProduct{Quantity: quantity, VatPercentage: money(vat), UnitPrice: money(unitPrice), VariantUUID: variant}
+}
+
+var testProducts = []Product{
+	{UUID: "beer", Name: "Beer", Category: Category{UUID: "pub", Name: "Pub"}, Variants: []Variant{
+		{UUID: "beer-large", Name: "Large", Barcode: "3010"},
+	}},
+	{UUID: "food", Name: "Food", Variants: []Variant{{UUID: "food", Barcode: "3020"}}},
+}
+
+// reportRowString formats the row so that the rows can be compared in tests
+func reportRowString(r ReportRow) string {
+	s := fmt.Sprintf("%dx %s %d %s%% %s", r.Count, r.Name, r.VismaAccount, r.VatPercentage, r.Amount.StringFixed(2))
+	if r.Refund {
+		s += " refund"
+	}
+	return s
+}
+
+func reportRowStrings(report Report) []string {
+	rows := []string{}
+	for _, r := range report.Rows {
+		rows = append(rows, reportRowString(r))
+	}
+	// The rows are not sorted by Reports
+	sort.Strings(rows)
+	return rows
+}
+
+func TestReports(t *testing.T) {
+	resolver, err := NewAccountResolver(nil, true, 3110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	purchases := Purchases{Purchases: []Purchase{
+		purchaseAt("1", "2020-10-13T18:00:00Z", 42,
+			purchaseProduct("beer-large", "2", "25", "62.5"),
+			purchaseProduct("food", "1", "12", "112")),
+		purchaseAt("2", "2020-10-13T19:00:00Z", 42,
+			purchaseProduct("beer-large", "1", "25", "62.5"),
+			purchaseProduct("custom", "1", "25", "10")),
+		// Still the 13th in Stockholm
+		purchaseAt("3", "2020-10-13T21:30:00Z", 42, purchaseProduct("food", "1", "12", "112")),
+		purchaseAt("4", "2020-10-14T12:00:00Z", 42, purchaseProduct("food", "1", "12", "112")),
+	}}
+	timeZone, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := Reports(purchases, testProducts, resolver, timeZone)
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Date.Before(reports[j].Date)
+	})
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 reports, got %d", len(reports))
+	}
+	report := reports[0]
+	if report.Date.String() != "2020-10-13" || report.UserID != 42 || report.Username != "ZIK" || report.PurchaseCount != 3 {
+		t.Errorf("unexpected report %s %d %s with %d purchases", report.Date.String(), report.UserID, report.Username, report.PurchaseCount)
+	}
+	want := []string{
+		"1x Custom product 3110 25% 10.00",
+		"2x Food 3020 12% 224.00",
+		"3x Beer, Large 3010 25% 187.50",
+	}
+	got := reportRowStrings(report)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	if !report.Sum().Equal(money("421.5").Decimal) {
+		t.Errorf("expected the sum 421.5, got %s", report.Sum())
+	}
+}
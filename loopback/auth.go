@@ -2,23 +2,113 @@ package loopback
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
 
 	"golang.org/x/oauth2"
 )
 
 type Auth struct {
-	Storage *Storage
+	Storage TokenStore
 	Oauth   *oauth2.Config
+	// PKCE sends a S256 code challenge with the login, so that a stolen
+	// code can not be exchanged without the verifier
+	PKCE bool
 }
 
+// AuthCodeURL returns the login URL, the verifier is only used with PKCE
+func (a *Auth) AuthCodeURL(state, verifier string) string {
+	if !a.PKCE {
+		return a.Oauth.AuthCodeURL(state)
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return a.Oauth.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+}
+
+// Exchange returns a token source for the code of a login made with the verifier
+func (a *Auth) Exchange(code, verifier string) (*oauth2.Token, error) {
+	var opts []oauth2.AuthCodeOption
+	if a.PKCE {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", verifier))
+	}
+	return a.Oauth.Exchange(context.Background(), code, opts...)
+}
+
+// Refresh returns a token source for the stored token, which is refreshed
+// right away if it has expired.
 func (a *Auth) Refresh(token *oauth2.Token) (oauth2.TokenSource, error) {
-	tokenSource := a.Oauth.TokenSource(context.Background(), token)
-	newToken, err := tokenSource.Token()
+	source := a.TokenSource(token)
+	_, err := source.Token()
 	if err != nil {
 		return nil, err
 	}
-	if newToken.AccessToken != token.AccessToken {
-		_ = a.Storage.Persist(*newToken)
+	return source, nil
+}
+
+// TokenSource returns a token source which saves every refreshed token. A
+// refresh token can only be used once, so the token is refreshed while
+// holding the lock of the store, and the stored token is used instead if
+// another run has refreshed it in the meantime.
+func (a *Auth) TokenSource(token *oauth2.Token) oauth2.TokenSource {
+	return &storedSource{auth: a, token: token}
+}
+
+type storedSource struct {
+	auth  *Auth
+	mutex sync.Mutex
+	token *oauth2.Token
+}
+
+func (s *storedSource) Token() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	unlock, err := s.auth.Storage.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if stored, err := s.auth.Storage.Load(); err == nil {
+		s.token = stored
+		if stored.Valid() {
+			return stored, nil
+		}
+	}
+	newToken, err := s.auth.Oauth.TokenSource(context.Background(), s.token).Token()
+	if err != nil {
+		return nil, err
+	}
+	err = s.auth.Storage.Persist(*newToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to save the refreshed token: %s", err)
+	}
+	s.token = newToken
+	return newToken, nil
+}
+
+// Persist saves the token of a new login while holding the lock of the store
+func (a *Auth) Persist(token oauth2.Token) error {
+	unlock, err := a.Storage.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return a.Storage.Persist(token)
+}
+
+// randomString returns a random URL safe string of n random bytes
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
 	}
-	return tokenSource, nil
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
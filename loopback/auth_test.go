@@ -0,0 +1,100 @@
+package loopback
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenServer hands out a new access and refresh token for every refresh,
+// and fails if a refresh token is used twice, like visma does.
+func tokenServer(t *testing.T) (*httptest.Server, *int) {
+	var mutex sync.Mutex
+	used := make(map[string]bool)
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		refreshToken := r.FormValue("refresh_token")
+		if used[refreshToken] {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		used[refreshToken] = true
+		refreshes++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"access-%d","refresh_token":"refresh-%d","token_type":"bearer","expires_in":3600}`, refreshes, refreshes)
+	}))
+	t.Cleanup(server.Close)
+	return server, &refreshes
+}
+
+func expiredToken() oauth2.Token {
+	return oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(-time.Hour)}
+}
+
+func TestRefreshPersistsToken(t *testing.T) {
+	server, _ := tokenServer(t)
+	store := &MemoryStore{}
+	_ = store.Persist(expiredToken())
+	auth := &Auth{Storage: store, Oauth: &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: server.URL}}}
+
+	token, _ := store.Load()
+	source, err := auth.Refresh(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := store.Load()
+	if stored.RefreshToken != "refresh-1" {
+		t.Errorf("expected the refreshed token to be saved, got %s", stored.RefreshToken)
+	}
+
+	// A refresh later in the run is saved as well
+	stored.Expiry = time.Now().Add(-time.Hour)
+	_ = store.Persist(*stored)
+	source.(*storedSource).token.Expiry = stored.Expiry
+	_, err = source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = store.Load()
+	if stored.RefreshToken != "refresh-2" {
+		t.Errorf("expected the token refreshed during the run to be saved, got %s", stored.RefreshToken)
+	}
+}
+
+func TestConcurrentRefreshUsesTheStoredToken(t *testing.T) {
+	server, refreshes := tokenServer(t)
+	store := NewStore(t.TempDir(), "test", nil)
+	_ = store.Persist(expiredToken())
+
+	// Two runs which loaded the same token before either refreshed it
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			auth := &Auth{Storage: store, Oauth: &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: server.URL}}}
+			token := expiredToken()
+			_, err := auth.Refresh(&token)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if *refreshes != 1 {
+		t.Errorf("expected the token to be refreshed once, got %d", *refreshes)
+	}
+}
@@ -1,12 +1,20 @@
 package loopback
 
-import "strconv"
+import (
+	"strconv"
+	"time"
+)
 
 type Config struct {
 	Port    int
 	TLSCert string
 	TLSKey  string
 	Auth    *Auth
+	// Manual prints the login URL and reads the redirected URL from stdin
+	// instead of opening a browser, for logging in over SSH
+	Manual bool
+	// Timeout is how long to wait for the browser login, 5 minutes if zero
+	Timeout time.Duration
 }
 
 const callbackPath = "/callback"
@@ -35,3 +43,10 @@ func (c *Config) Localhost() string {
 	}
 	return localAddr
 }
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return 5 * time.Minute
+	}
+	return c.Timeout
+}
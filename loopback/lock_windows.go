@@ -0,0 +1,6 @@
+package loopback
+
+// lockFile does not lock on windows, where the tool is only run by hand
+func lockFile(filename string) (func(), error) {
+	return func() {}, nil
+}
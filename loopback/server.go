@@ -1,8 +1,16 @@
 package loopback
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/pkg/browser"
@@ -25,77 +33,164 @@ func (s *Server) LoginOrRefresh() (oauth2.TokenSource, error) {
 	token, _ := s.Auth.Storage.Load()
 	if token != nil {
 		return s.Auth.Refresh(token)
+	}
+	// The state makes sure that the code we get was requested by us,
+	// and not by someone else who sent the browser to the callback.
+	state, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	authURL := s.Auth.AuthCodeURL(state, verifier)
+	var code string
+	if s.Manual {
+		code, err = s.ManualLogin(authURL, state)
 	} else {
-		// This nonce should be random and checked in the browser if this
-		// was a web-application. Since the server only runs for a few
-		// seconds we do not worry about CSRF attacks.
-		url := s.Auth.Oauth.AuthCodeURL("abc123")
-		source, err := s.LoopbackLogin(url)
-		if err != nil {
-			return nil, err
+		code, err = s.LoopbackLogin(authURL, state)
+	}
+	if err != nil {
+		return nil, err
+	}
+	token, err = s.Auth.Exchange(code, verifier)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Auth.Persist(*token)
+	if err != nil {
+		return nil, fmt.Errorf("unable to save the token: %s", err)
+	}
+	return s.Auth.TokenSource(token), nil
+}
+
+// LoopbackLogin opens the login URL in a browser and returns the code which
+// the browser is redirected back with. It gives up after the timeout, in
+// case the user closes the tab.
+func (s *Server) LoopbackLogin(authURL, state string) (string, error) {
+	// The channels are buffered so that the handlers never wait for a
+	// login which has already given up.
+	codeCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+
+	server := &http.Server{Addr: s.Localhost()}
+	handler := http.NewServeMux()
+	handler.HandleFunc(loginPath, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+	})
+	handler.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
+		code, err := callbackCode(r.URL.Query(), state)
+		if err == errWrongState {
+			// Not the redirect of our login, which we keep waiting for
+			writePage(w, http.StatusBadRequest, "Login failed", err.Error()+".")
+			return
 		}
-		token, err = source.Token()
 		if err != nil {
-			return nil, err
+			writePage(w, http.StatusBadRequest, "Login failed", err.Error()+". Please close this tab and try again.")
+			sendError(errCh, err)
+			return
+		}
+		writePage(w, http.StatusOK, "Logged in", "You are now logged in! Please close this tab.")
+		select {
+		case codeCh <- code:
+		default:
+		}
+	})
+	server.Handler = handler
+	go func() {
+		var err error
+		if s.TLSCert != "" {
+			err = server.ListenAndServeTLS(s.TLSCert, s.TLSKey)
+		} else {
+			err = server.ListenAndServe()
 		}
-		_ = s.Auth.Storage.Persist(*token)
-		return source, nil
+		if err != http.ErrServerClosed {
+			sendError(errCh, err)
+		}
+	}()
+	// The page of the callback is sent before the server shuts down
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	err := browser.OpenURL(s.LoginURL())
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case code := <-codeCh:
+		return code, nil
+	case err := <-errCh:
+		return "", err
+	case <-time.After(s.timeout()):
+		return "", fmt.Errorf("gave up waiting for the login after %s", s.timeout())
 	}
 }
 
-func (s *Server) LoopbackLogin(url string) (oauth2.TokenSource, error) {
-	tokenCh := make(chan string)
-	errCh := make(chan error)
-
-	{
-		server := &http.Server{Addr: s.Localhost()}
-		handler := http.NewServeMux()
-		handler.HandleFunc(loginPath, func(w http.ResponseWriter, r *http.Request) {
-			http.Redirect(w, r, url, http.StatusTemporaryRedirect)
-		})
-		handler.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
-			code := r.FormValue("code")
-			_, _ = w.Write([]byte("You are now logged in! Please close this tab"))
-			go func() {
-				select {
-				case <-time.After(6 * time.Second):
-					_ = server.Shutdown(context.Background())
-				}
-			}()
-			tokenCh <- code
-		})
-		server.Handler = handler
-		go func() {
-			var err error
-			if s.TLSCert != "" {
-				err = server.ListenAndServeTLS(s.TLSCert, s.TLSKey)
-			} else {
-				err = server.ListenAndServe()
-			}
-			if err != http.ErrServerClosed {
-				errCh <- err
-			}
-		}()
-	}
-
-	{
-		err := browser.OpenURL(s.LoginURL())
-		if err != nil {
-			return nil, err
-		}
+// ManualLogin lets the user open the login URL in a browser on any computer.
+// The browser is redirected to the loopback address, which does not load
+// when the computer is not the one we run on, so the user pastes the
+// redirected URL or just the code instead. The state can only be checked
+// when the URL is pasted.
+func (s *Server) ManualLogin(authURL, state string) (string, error) {
+	fmt.Printf("\n\nOpen this URL in a browser and log in:\n\n%s\n\n", authURL)
+	fmt.Printf("The browser is then sent to %s, which fails to load.\nPaste the URL of that page, or the code in it: ", s.RedirectURL())
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
 	}
+	return parseCode(strings.TrimSpace(line), state)
+}
 
-	{
-		select {
-		case code := <-tokenCh:
-			token, err := s.Auth.Oauth.Exchange(context.Background(), code)
-			if err != nil {
-				return nil, err
-			}
-			source := s.Auth.Oauth.TokenSource(context.Background(), token)
-			return source, nil
-		case err := <-errCh:
-			return nil, err
-		}
+// parseCode returns the code of a pasted redirect URL, or the pasted text if it is not a URL
+func parseCode(pasted, state string) (string, error) {
+	if pasted == "" {
+		return "", fmt.Errorf("no code was given")
+	}
+	if !strings.Contains(pasted, "?") {
+		return pasted, nil
+	}
+	u, err := url.Parse(pasted)
+	if err != nil {
+		return "", err
+	}
+	return callbackCode(u.Query(), state)
+}
+
+// errWrongState is returned for redirects of logins which we did not start
+var errWrongState = errors.New("the login was not started by us, the state does not match")
+
+// callbackCode returns the code of the redirect, or the error which the
+// provider redirected with. The state is checked first, so that only the
+// redirect of our own login can make it fail.
+func callbackCode(query url.Values, state string) (string, error) {
+	if query.Get("state") != state {
+		return "", errWrongState
+	}
+	if e := query.Get("error"); e != "" {
+		return "", fmt.Errorf("the login failed: %s %s", e, query.Get("error_description"))
 	}
+	code := query.Get("code")
+	if code == "" {
+		return "", fmt.Errorf("the redirect does not contain a code")
+	}
+	return code, nil
+}
+
+func sendError(errCh chan error, err error) {
+	select {
+	case errCh <- err:
+	default:
+	}
+}
+
+func writePage(w http.ResponseWriter, status int, title, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<!DOCTYPE html><html><head><title>%s</title></head><body><h1>%s</h1><p>%s</p></body></html>",
+		html.EscapeString(title), html.EscapeString(title), html.EscapeString(message))
 }
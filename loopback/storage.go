@@ -4,44 +4,125 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"izettle-daily-reports/vault"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"golang.org/x/oauth2"
 )
 
-type Storage struct {
+// TokenStore saves the token of a login between runs. Persist should only
+// be called while holding the lock, so that two runs do not refresh the
+// same token at once.
+type TokenStore interface {
+	Load() (*oauth2.Token, error)
+	Persist(token oauth2.Token) error
+	// Lock waits until no other run holds the lock
+	Lock() (unlock func(), err error)
+}
+
+// NewStore returns a store for the token in the folder, which is encrypted if box is not nil
+func NewStore(dir, name string, box *vault.Box) TokenStore {
+	file := FileStore{Dir: dir, Name: name}
+	if box == nil {
+		return &file
+	}
+	return &EncryptedStore{FileStore: file, Box: box}
+}
+
+// FileStore saves the token as json in Dir, only readable by the user
+type FileStore struct {
+	Dir  string
 	Name string
 }
 
-func (s *Storage) Load() (*oauth2.Token, error) {
+func (s *FileStore) Load() (*oauth2.Token, error) {
 	bytes, err := ioutil.ReadFile(s.Filename())
 	if err != nil {
 		return nil, err
 	}
-	token := oauth2.Token{}
-	err = json.Unmarshal(bytes, &token)
-	if err != nil {
-		return nil, err
+	if vault.IsSealed(bytes) {
+		return nil, fmt.Errorf("%s is encrypted, set a passphrase or key file to read it", s.Filename())
 	}
-	return &token, nil
+	return unmarshalToken(bytes)
 }
 
-func (s *Storage) Persist(token oauth2.Token) error {
-	if token.RefreshToken == "" {
-		return fmt.Errorf("can not perist oauth token without refreshToken")
-	}
-	bytes, err := json.Marshal(token)
+func (s *FileStore) Persist(token oauth2.Token) error {
+	bytes, err := marshalToken(token)
 	if err != nil {
 		return err
 	}
-	err = os.MkdirAll("tokens", 0775)
+	return vault.WriteFile(s.Filename(), bytes, nil)
+}
+
+func (s *FileStore) Lock() (func(), error) {
+	return lockFile(s.Filename() + ".lock")
+}
+
+func (s *FileStore) Filename() string {
+	return filepath.Join(s.Dir, s.Name+".token")
+}
+
+// EncryptedStore saves the token encrypted by Box. Tokens saved by a
+// FileStore are read as is, and encrypted the next time they are saved.
+type EncryptedStore struct {
+	FileStore
+	Box *vault.Box
+}
+
+func (s *EncryptedStore) Load() (*oauth2.Token, error) {
+	bytes, err := vault.ReadFile(s.Filename(), s.Box)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalToken(bytes)
+}
+
+func (s *EncryptedStore) Persist(token oauth2.Token) error {
+	bytes, err := marshalToken(token)
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(s.Filename(), bytes, 0755)
-	return err
+	return vault.WriteFile(s.Filename(), bytes, s.Box)
+}
+
+// MemoryStore keeps the token for the current run only
+type MemoryStore struct {
+	mutex sync.Mutex
+	token *oauth2.Token
 }
 
-func (s *Storage) Filename() string {
-	return "tokens/" + s.Name + ".token"
+func (s *MemoryStore) Load() (*oauth2.Token, error) {
+	if s.token == nil {
+		return nil, os.ErrNotExist
+	}
+	token := *s.token
+	return &token, nil
+}
+
+func (s *MemoryStore) Persist(token oauth2.Token) error {
+	s.token = &token
+	return nil
+}
+
+func (s *MemoryStore) Lock() (func(), error) {
+	s.mutex.Lock()
+	return s.mutex.Unlock, nil
+}
+
+func marshalToken(token oauth2.Token) ([]byte, error) {
+	if token.RefreshToken == "" {
+		return nil, fmt.Errorf("can not perist oauth token without refreshToken")
+	}
+	return json.Marshal(token)
+}
+
+func unmarshalToken(bytes []byte) (*oauth2.Token, error) {
+	token := oauth2.Token{}
+	err := json.Unmarshal(bytes, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
 }
@@ -0,0 +1,123 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Font is one of the standard PDF fonts, which every PDF reader has and
+// which therefore do not have to be embedded.
+type Font string
+
+const Regular Font = "F1"
+const Bold Font = "F2"
+
+// Mono is a fixed width font, which is used for numbers since it can be
+// right aligned without knowing the width of each character.
+const Mono Font = "F3"
+
+// A4 page size in points
+const PageWidth = 595.0
+const PageHeight = 842.0
+
+var fontNames = map[Font]string{
+	Regular: "Helvetica",
+	Bold:    "Helvetica-Bold",
+	Mono:    "Courier",
+}
+
+// Document is a minimal PDF writer which only supports text and lines.
+// Text is encoded with WinAnsiEncoding, so Swedish characters work but
+// characters outside of Latin-1 are replaced with a question mark.
+type Document struct {
+	pages []*bytes.Buffer
+}
+
+func New() *Document {
+	d := &Document{}
+	d.AddPage()
+	return d
+}
+
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *Document) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// Text writes the text with its baseline starting at x, y. The origin is the
+// bottom left corner of the page.
+func (d *Document) Text(font Font, size, x, y float64, text string) {
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(text))
+}
+
+// TextRight writes the text in the Mono font so that it ends at x
+func (d *Document) TextRight(size, x, y float64, text string) {
+	width := float64(len([]rune(text))) * 0.6 * size
+	d.Text(Mono, size, x-width, y, text)
+}
+
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// Bytes returns the document as a PDF file
+func (d *Document) Bytes() []byte {
+	out := &bytes.Buffer{}
+	offsets := []int{}
+	object := func(content string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", len(offsets), content)
+	}
+
+	// The comment with binary characters tells file transfers that
+	// the file is binary.
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// Object 1 is the catalog, 2 the page tree, 3-5 the fonts and then
+	// each page followed by its content.
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	for _, font := range []Font{Regular, Bold, Mono} {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", fontNames[font]))
+	}
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>", PageWidth, PageHeight, 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// escape encodes the text as WinAnsi and escapes the characters which have
+// a special meaning in PDF strings.
+func escape(text string) string {
+	b := &strings.Builder{}
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r == '€':
+			b.WriteByte(0x80)
+		case r < 0x20 || (r >= 0x7f && r < 0xa0) || r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
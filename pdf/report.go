@@ -0,0 +1,128 @@
+package pdf
+
+import (
+	"fmt"
+	"izettle-daily-reports/izettle"
+	"izettle-daily-reports/util"
+	"sort"
+	"strings"
+)
+
+const margin = 50.0
+const lineHeight = 14.0
+const fontSize = 10.0
+
+// Columns of the tables, the amounts are right aligned at their column
+const countColumn = margin
+const nameColumn = margin + 40
+const amountColumn = PageWidth - margin
+const vatColumn = amountColumn - 110
+
+// reportWriter keeps track of where the next line of the report goes
+type reportWriter struct {
+	doc *Document
+	y   float64
+}
+
+// DayReport renders a Z-report of the sales made by a user during a day,
+// like the daily report PDF which can be downloaded from my.izettle.com.
+func DayReport(report izettle.Report) []byte {
+	w := &reportWriter{doc: New(), y: PageHeight - margin}
+	w.doc.Text(Bold, 16, margin, w.y, "Z-report")
+	w.y -= 24
+	w.field("Date", report.Date.String())
+	w.field("Seller", report.Username)
+	w.field("Sales", fmt.Sprintf("%d", report.PurchaseCount))
+	w.field("Refunds", fmt.Sprintf("%d", report.RefundCount))
+
+	rows := make([]izettle.ReportRow, len(report.Rows))
+	copy(rows, report.Rows)
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Refund != rows[j].Refund {
+			return !rows[i].Refund
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	w.heading("Products", "VAT", "Amount")
+	for _, r := range rows {
+		if r.Refund {
+			continue
+		}
+		w.row(fmt.Sprintf("%dx", r.Count), r.Name, percent(r.VatPercentage), money(r.Amount))
+	}
+	if report.RefundCount > 0 {
+		w.heading("Refunded products", "VAT", "Amount")
+		for _, r := range rows {
+			if r.Refund {
+				w.row(fmt.Sprintf("%dx", r.Count), r.Name, percent(r.VatPercentage), money(r.Amount))
+			}
+		}
+		w.total("Total refunds", money(report.Refunds()))
+	}
+	w.total("Total", money(report.Sum()))
+
+	w.heading("VAT", "Net", "VAT")
+	for _, v := range report.VatRows() {
+		w.row("", percent(v.VatPercentage), money(v.NetAmount()), money(v.VatAmount))
+	}
+
+	w.heading("Payments", "", "Amount")
+	for _, p := range report.Payments {
+		w.row("", paymentName(p.Type), "", money(p.Amount))
+	}
+	return w.doc.Bytes()
+}
+
+func (w *reportWriter) newLine(height float64) {
+	w.y -= height
+	if w.y < margin {
+		w.doc.AddPage()
+		w.y = PageHeight - margin - height
+	}
+}
+
+func (w *reportWriter) field(name, value string) {
+	w.doc.Text(Bold, fontSize, margin, w.y, name)
+	w.doc.Text(Regular, fontSize, nameColumn+40, w.y, value)
+	w.newLine(lineHeight)
+}
+
+func (w *reportWriter) heading(name, vat, amount string) {
+	w.newLine(lineHeight)
+	w.doc.Text(Bold, fontSize+2, countColumn, w.y, name)
+	w.doc.Text(Bold, fontSize, vatColumn-float64(len(vat))*fontSize*0.6, w.y, vat)
+	w.doc.Text(Bold, fontSize, amountColumn-float64(len(amount))*fontSize*0.6, w.y, amount)
+	w.newLine(4)
+	w.doc.Line(margin, w.y, PageWidth-margin, w.y)
+	w.newLine(lineHeight)
+}
+
+func (w *reportWriter) row(count, name, vat, amount string) {
+	w.doc.Text(Regular, fontSize, countColumn, w.y, count)
+	w.doc.Text(Regular, fontSize, nameColumn, w.y, name)
+	w.doc.TextRight(fontSize, vatColumn, w.y, vat)
+	w.doc.TextRight(fontSize, amountColumn, w.y, amount)
+	w.newLine(lineHeight)
+}
+
+func (w *reportWriter) total(name, amount string) {
+	w.doc.Line(vatColumn, w.y+lineHeight-3, PageWidth-margin, w.y+lineHeight-3)
+	w.doc.Text(Bold, fontSize, nameColumn, w.y, name)
+	w.doc.TextRight(fontSize, amountColumn, w.y, amount)
+	w.newLine(lineHeight)
+}
+
+func money(m util.Money) string {
+	return m.StringFixed(2)
+}
+
+func percent(m util.Money) string {
+	return m.String() + "%"
+}
+
+// paymentName makes the payment types of iZettle readable,
+// e.g. IZETTLE_CARD becomes Card.
+func paymentName(paymentType string) string {
+	name := strings.TrimPrefix(paymentType, "IZETTLE_")
+	return strings.Title(strings.ToLower(strings.Replace(name, "_", " ", -1)))
+}
@@ -13,9 +13,10 @@ fi
 if [ ! -d tokens ]; then
   mkdir tokens
 fi
+chmod 700 tokens
 if [ ! -d pdfs ]; then
   mkdir pdfs
 fi
 rm pdfs/*
-go run cmd/sync-report/main.go
+go run ./cmd/sync-report sync "$@"
 rm pdfs/*
@@ -102,3 +102,22 @@ func (d Date) MarshalJSON() ([]byte, error) {
 	b = append(b, '"')
 	return b, nil
 }
+
+// DateFromString parses a date formatted as 2006-01-02
+func DateFromString(t string) (Date, error) {
+	date, err := time.Parse("2006-01-02", t)
+	if err != nil {
+		return Date{}, err
+	}
+	return Date{date}, nil
+}
+
+// ContainsString returns true if the string is in the list
+func ContainsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
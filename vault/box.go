@@ -0,0 +1,166 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// magic starts every sealed file, so that files written before they were
+// encrypted can still be read.
+const magic = "izdr-box1\n"
+
+const saltSize = 16
+const nonceSize = 24
+
+// Box encrypts data with NaCl secretbox. The key is derived from a
+// passphrase with scrypt and a random salt which is stored with the data.
+type Box struct {
+	passphrase []byte
+	keys       map[string]*[32]byte
+}
+
+func NewBox(passphrase string) *Box {
+	return &Box{passphrase: []byte(passphrase), keys: make(map[string]*[32]byte)}
+}
+
+// ReadKeyFile uses the content of the file as passphrase. The file must
+// only be readable by the user, since anyone who can read it can decrypt.
+func ReadKeyFile(filename string) (*Box, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("the key file %s can be read by other users, run 'chmod 600 %s'", filename, filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	passphrase := string(bytes.TrimSpace(data))
+	if passphrase == "" {
+		return nil, fmt.Errorf("the key file %s is empty", filename)
+	}
+	return NewBox(passphrase), nil
+}
+
+func (b *Box) key(salt []byte) (*[32]byte, error) {
+	if key, ok := b.keys[string(salt)]; ok {
+		return key, nil
+	}
+	derived, err := scrypt.Key(b.passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	key := &[32]byte{}
+	copy(key[:], derived)
+	b.keys[string(salt)] = key
+	return key, nil
+}
+
+func (b *Box) Seal(data []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [nonceSize]byte
+	_, err = io.ReadFull(rand.Reader, nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	key, err := b.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(magic), salt...)
+	out = append(out, nonce[:]...)
+	return secretbox.Seal(out, data, &nonce, key), nil
+}
+
+func (b *Box) Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) || len(data) < len(magic)+saltSize+nonceSize {
+		return nil, fmt.Errorf("the data is not encrypted")
+	}
+	data = data[len(magic):]
+	key, err := b.key(data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], data[saltSize:])
+	opened, ok := secretbox.Open(nil, data[saltSize+nonceSize:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("unable to decrypt, the passphrase is wrong")
+	}
+	return opened, nil
+}
+
+// IsSealed returns true if the data was encrypted by a box
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// WriteFile writes the data to a file which only the user can read, in a
+// folder which only the user can list. The data is encrypted if box is not nil.
+// The file is replaced by a rename so that it is never left half written.
+func WriteFile(filename string, data []byte, box *Box) error {
+	if box != nil {
+		var err error
+		data, err = box.Seal(data)
+		if err != nil {
+			return err
+		}
+	}
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	// MkdirAll keeps the mode of a directory which already exists
+	err = os.Chmod(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	// A unique temporary file, so that two runs never write to the same one
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// ReadFile reads a file written by WriteFile. Files written without a box
+// are read as is, with a warning if there is a box, so that they are
+// encrypted the next time they are written.
+func ReadFile(filename string, box *Box) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSealed(data) {
+		if box != nil {
+			fmt.Printf("\n * %s is not encrypted, it is encrypted the next time it is saved.\n", filename)
+		}
+		return data, nil
+	}
+	if box == nil {
+		return nil, fmt.Errorf("%s is encrypted, set a passphrase or key file to read it", filename)
+	}
+	return box.Open(data)
+}
@@ -0,0 +1,60 @@
+package vault
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	sealed, err := NewBox("passphrase").Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) {
+		t.Error("expected the data to be sealed")
+	}
+	opened, err := NewBox("passphrase").Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opened) != "secret" {
+		t.Errorf("expected secret, got %s", opened)
+	}
+	_, err = NewBox("wrong").Open(sealed)
+	if err == nil {
+		t.Error("expected the wrong passphrase to fail")
+	}
+}
+
+func TestWriteReadFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tokens")
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "test.token")
+	box := NewBox("passphrase")
+	err = WriteFile(filename, []byte("secret"), box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("expected the directory to only be accessible by the user, got %s", info.Mode().Perm())
+	}
+	data, err := ReadFile(filename, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "secret" {
+		t.Errorf("expected secret, got %s", data)
+	}
+	_, err = ReadFile(filename, nil)
+	if err == nil {
+		t.Error("expected a sealed file to need a box")
+	}
+}
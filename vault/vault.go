@@ -0,0 +1,66 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Vault is an encrypted file of named secrets, such as passwords and
+// client secrets which should not be in the config file.
+type Vault struct {
+	filename string
+	box      *Box
+	Secrets  map[string]string
+}
+
+// OpenVault reads the vault, or starts a new one if the file does not exist
+func OpenVault(filename string, box *Box) (*Vault, error) {
+	if box == nil {
+		return nil, fmt.Errorf("a passphrase or key file is needed to open the vault %s", filename)
+	}
+	v := &Vault{filename: filename, box: box, Secrets: make(map[string]string)}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The vault is always written encrypted, so a plain file was not made by us
+	if !IsSealed(data) {
+		return nil, fmt.Errorf("the vault %s is not encrypted", filename)
+	}
+	data, err = box.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the vault %s: %s", filename, err)
+	}
+	if v.Secrets == nil {
+		v.Secrets = make(map[string]string)
+	}
+	return v, nil
+}
+
+func (v *Vault) Get(name string) (string, bool) {
+	secret, ok := v.Secrets[name]
+	return secret, ok
+}
+
+// Set saves the secret, an empty secret is removed
+func (v *Vault) Set(name, secret string) error {
+	if secret == "" {
+		delete(v.Secrets, name)
+	} else {
+		v.Secrets[name] = secret
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return WriteFile(v.filename, data, v.box)
+}
@@ -0,0 +1,84 @@
+package visma
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Account is an account of the chart of accounts of a fiscal year
+type Account struct {
+	Number                    int
+	Name                      string
+	FiscalYearID              string
+	VatCodeID                 string
+	VatCodeDescription        string
+	IsActive                  bool
+	IsProjectAllowed          bool
+	IsCostCenterAllowed       bool
+	IsBlockedForManualBooking bool
+}
+
+func (a *Account) UnmarshalJSON(data []byte) error {
+	// The number is sent as a string, but is an int everywhere else
+	resp := struct {
+		Number                    json.RawMessage `json:"Number"`
+		Name                      string          `json:"Name"`
+		FiscalYearID              string          `json:"FiscalYearId"`
+		VatCodeID                 string          `json:"VatCodeId"`
+		VatCodeDescription        string          `json:"VatCodeDescription"`
+		IsActive                  bool            `json:"IsActive"`
+		IsProjectAllowed          bool            `json:"IsProjectAllowed"`
+		IsCostCenterAllowed       bool            `json:"IsCostCenterAllowed"`
+		IsBlockedForManualBooking bool            `json:"IsBlockedForManualBooking"`
+	}{}
+	err := json.Unmarshal(data, &resp)
+	if err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(strings.Trim(string(resp.Number), `"`))
+	if err != nil {
+		return fmt.Errorf("invalid account number %s", string(resp.Number))
+	}
+	*a = Account{
+		Number:                    number,
+		Name:                      resp.Name,
+		FiscalYearID:              resp.FiscalYearID,
+		VatCodeID:                 resp.VatCodeID,
+		VatCodeDescription:        resp.VatCodeDescription,
+		IsActive:                  resp.IsActive,
+		IsProjectAllowed:          resp.IsProjectAllowed,
+		IsCostCenterAllowed:       resp.IsCostCenterAllowed,
+		IsBlockedForManualBooking: resp.IsBlockedForManualBooking,
+	}
+	return nil
+}
+
+// Accounts returns the chart of accounts of the fiscal year
+func (c *Client) Accounts(fiscalYearID string) ([]Account, error) {
+	accounts := []Account{}
+	err := c.GetAllPages("accounts/"+fiscalYearID, nil, func(data []byte) error {
+		var page []Account
+		err := json.Unmarshal(data, &page)
+		if err != nil {
+			return err
+		}
+		accounts = append(accounts, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// FindAccount returns the account with the number, or nil if there is none
+func FindAccount(accounts []Account, number int) *Account {
+	for i := range accounts {
+		if accounts[i].Number == number {
+			return &accounts[i]
+		}
+	}
+	return nil
+}
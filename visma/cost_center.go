@@ -1,6 +1,10 @@
 package visma
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
 
 type CostCenterItem struct {
 	CostCenterID string    `json:"CostCenterId"`
@@ -30,3 +34,23 @@ func (c *Client) CostCenters() ([]CostCenter, error) {
 	}
 	return resp.Data, nil
 }
+
+// FindCostCenter returns the cost center with the name or number
+func FindCostCenter(costCenters []CostCenter, nameOrNumber string) (*CostCenter, error) {
+	for i, cc := range costCenters {
+		if cc.Name == nameOrNumber || strconv.Itoa(cc.Number) == nameOrNumber {
+			return &costCenters[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unable to find cost center: %s", nameOrNumber)
+}
+
+// FindItem returns the item with the name or short name
+func (c *CostCenter) FindItem(name string) (*CostCenterItem, error) {
+	for i, item := range c.Items {
+		if item.Name == name || item.ShortName == name {
+			return &c.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unable to find %s in the cost center %s", name, c.Name)
+}
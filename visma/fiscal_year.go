@@ -40,3 +40,23 @@ func (c *Client) CurrentFiscalYear() (*FiscalYear, error) {
 	}
 	return nil, fmt.Errorf("failed to get current year")
 }
+
+// Contains returns true if the date is within the fiscal year
+func (y *FiscalYear) Contains(date util.Date) bool {
+	return !date.Before(y.StartDate) && !date.After(y.EndDate)
+}
+
+// Overlaps returns true if any date between the two dates is within the fiscal year
+func (y *FiscalYear) Overlaps(fromDate util.Date, toDate util.Date) bool {
+	return !toDate.Before(y.StartDate) && !fromDate.After(y.EndDate)
+}
+
+// FindFiscalYear returns the fiscal year which the date belongs to
+func FindFiscalYear(years []FiscalYear, date util.Date) (*FiscalYear, error) {
+	for i := range years {
+		if years[i].Contains(date) {
+			return &years[i], nil
+		}
+	}
+	return nil, fmt.Errorf("there is no fiscal year for %s", date.String())
+}
@@ -1,7 +1,6 @@
 package visma
 
 import (
-	"fmt"
 	"izettle-daily-reports/loopback"
 
 	"golang.org/x/oauth2"
@@ -16,13 +15,17 @@ type Environment struct {
 	TokenURL     string
 }
 
-func Login(environment Environment) (*Client, error) {
+// Login refreshes the token in the store, or logs in with a browser if there
+// is none. A manual login prints the login URL instead of opening a browser.
+func Login(environment Environment, store loopback.TokenStore, manual bool) (*Client, error) {
 	server := loopback.New(loopback.Config{
 		Port:    44300,
 		TLSCert: "server.crt",
 		TLSKey:  "server.key",
+		Manual:  manual,
 		Auth: &loopback.Auth{
-			Storage: &loopback.Storage{Name: fmt.Sprintf("visma-%s", environment.Name)},
+			Storage: store,
+			PKCE:    true,
 			Oauth: &oauth2.Config{
 				ClientID:     environment.ClientID,
 				ClientSecret: environment.ClientSecret,
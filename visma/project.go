@@ -1,6 +1,7 @@
 package visma
 
 import (
+	"encoding/json"
 	"fmt"
 	"izettle-daily-reports/util"
 	"time"
@@ -26,26 +27,27 @@ func (c *Client) Projects(id ...string) ([]Project, error) {
 	} else if len(id) == 1 {
 		resource = resource + "/" + id[0]
 	}
-	page := 1
-	pageSize := 1000
+	return c.projects(resource, nil)
+}
+
+// FindProjects returns the projects matching the query
+func (c *Client) FindProjects(query *Query) ([]Project, error) {
+	return c.projects("projects", query)
+}
+
+func (c *Client) projects(resource string, query *Query) ([]Project, error) {
 	projects := []Project{}
-	for {
-		resp := struct {
-			Meta Meta
-			Data []Project
-		}{}
-		err := c.GetRequestPage(resource, page, pageSize, &resp)
+	err := c.GetAllPages(resource, query, func(data []byte) error {
+		var page []Project
+		err := json.Unmarshal(data, &page)
 		if err != nil {
-			return nil, err
-		}
-		for _, p := range resp.Data {
-			projects = append(projects, p)
-		}
-		if resp.Meta.CurrentPage >= resp.Meta.TotalNumberOfPages {
-			break
+			return err
 		}
-		page = resp.Meta.CurrentPage + 1
-		pageSize = resp.Meta.PageSize
+		projects = append(projects, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return projects, nil
 }
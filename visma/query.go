@@ -0,0 +1,71 @@
+package visma
+
+import (
+	"fmt"
+	"izettle-daily-reports/util"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OData comparison operators
+const Eq = "eq"
+const Ne = "ne"
+const Gt = "gt"
+const Ge = "ge"
+const Lt = "lt"
+const Le = "le"
+
+// Query builds the OData options used to ask the API for only some of the
+// items of a resource.
+type Query struct {
+	filters []string
+	orderBy string
+}
+
+func NewQuery() *Query {
+	return &Query{}
+}
+
+// Filter adds a condition which all returned items must fulfill,
+// the conditions are combined using and.
+func (q *Query) Filter(field string, operator string, value interface{}) *Query {
+	q.filters = append(q.filters, fmt.Sprintf("%s %s %s", field, operator, formatValue(value)))
+	return q
+}
+
+func (q *Query) OrderBy(field string) *Query {
+	q.orderBy = field
+	return q
+}
+
+// Encode returns the query options, without a leading ?
+func (q *Query) Encode() string {
+	var options []string
+	if len(q.filters) > 0 {
+		options = append(options, "$filter="+escape(strings.Join(q.filters, " and ")))
+	}
+	if q.orderBy != "" {
+		options = append(options, "$orderby="+escape(q.orderBy))
+	}
+	return strings.Join(options, "&")
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case util.Date:
+		return v.String()
+	case time.Time:
+		return v.UTC().Format("2006-01-02T15:04:05Z")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// escape escapes the value for use in the query, spaces are escaped as %20
+// since the API does not treat + as a space.
+func escape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
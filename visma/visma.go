@@ -40,52 +40,144 @@ func (c *Client) Http() (*httpclient.HttpClient, error) {
 }
 
 func (c *Client) GetRequest(resource string, respType interface{}) error {
-	http, err := c.Http()
-	if err != nil {
-		return err
-	}
+	// Retrying a GET is always safe, even if the request might have
+	// reached visma.
+	return c.request("GET", true, respType, func(http *httpclient.HttpClient) (*httpclient.Response, error) {
+		return http.Get(c.URL(resource))
+	})
+}
 
-	//client := &http.Client{}
-	resp, err := http.Get(c.URL(resource))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+// GetRequestPage fetches a single page of the resource, if the query is nil
+// the items are ordered by their number.
+func (c *Client) GetRequestPage(resource string, query *Query, page, pageSize int, respType interface{}) error {
+	if query == nil {
+		query = NewQuery().OrderBy("Number")
 	}
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("GET request failed: %s. Got '%s' for %s", string(respData), resp.Status, resp.Request.URL)
+	search := fmt.Sprintf("?$pagesize=%d&$page=%d", pageSize, page)
+	if options := query.Encode(); options != "" {
+		search += "&" + options
 	}
-	return json.Unmarshal(respData, respType)
+	return c.GetRequest(resource+search, respType)
 }
 
-func (c *Client) GetRequestPage(resource string, page, pageSize int, respType interface{}) error {
-	search := fmt.Sprintf("?$pagesize=%d&$page=%d&$orderby=Number", pageSize, page)
-	return c.GetRequest(resource+search, respType)
+// GetAllPages fetches every page of the resource matching the query,
+// add is called with the data of each page.
+func (c *Client) GetAllPages(resource string, query *Query, add func(data []byte) error) error {
+	page := 1
+	pageSize := 1000
+	for {
+		resp := struct {
+			Meta Meta
+			Data json.RawMessage
+		}{}
+		err := c.GetRequestPage(resource, query, page, pageSize, &resp)
+		if err != nil {
+			return err
+		}
+		err = add(resp.Data)
+		if err != nil {
+			return err
+		}
+		if resp.Meta.CurrentPage >= resp.Meta.TotalNumberOfPages {
+			return nil
+		}
+		page = resp.Meta.CurrentPage + 1
+		pageSize = resp.Meta.PageSize
+	}
 }
 
 func (c *Client) PostRequest(resource string, reqType interface{}, respType interface{}) error {
-	http, err := c.Http()
+	form, err := query.Values(reqType)
 	if err != nil {
 		return err
 	}
-	form, err := query.Values(reqType)
+	// A POST which failed without a response, or with a bad gateway or
+	// gateway timeout, might still have created something in visma, so it
+	// is only retried when visma tells us that it was never handled. The
+	// upload journal and the matching of the next run pick up the rest.
+	return c.request("POST", false, respType, func(http *httpclient.HttpClient) (*httpclient.Response, error) {
+		return http.Post(c.URL(resource), form)
+	})
+}
+
+// maxAttempts is how many times a request is sent before giving up
+const maxAttempts = 5
+
+// request sends the request until it succeeds, fails permanently or has been
+// sent maxAttempts times. The wait between the attempts is doubled each time.
+// Requests which are not idempotent are only resent if visma never handled them.
+func (c *Client) request(method string, idempotent bool, respType interface{}, send func(http *httpclient.HttpClient) (*httpclient.Response, error)) error {
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		respData, err := c.send(method, send)
+		if err == nil {
+			return json.Unmarshal(respData, respType)
+		}
+		transient := isTransient(err, idempotent)
+		if _, ok := err.(*RequestError); !ok && idempotent {
+			transient = true
+		}
+		if !transient || attempt == maxAttempts {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (c *Client) send(method string, send func(http *httpclient.HttpClient) (*httpclient.Response, error)) ([]byte, error) {
+	http, err := c.Http()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	resp, err := http.Post(c.URL(resource), form)
+	resp, err := send(http)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	respData, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("POST request failed: %s. Got '%s' for %s", string(respData), resp.Status, resp.Request.URL)
+		return nil, &RequestError{
+			Method:     method,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			URL:        resp.Request.URL.String(),
+			Body:       string(respData),
+		}
+	}
+	return respData, nil
+}
+
+// RequestError is returned when visma answers with a non 2xx status
+type RequestError struct {
+	Method     string
+	StatusCode int
+	Status     string
+	URL        string
+	Body       string
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s request failed: %s. Got '%s' for %s", e.Method, e.Body, e.Status, e.URL)
+}
+
+// isTransient returns true if the request can be sent again. Visma never
+// handles a request it answers with 429 or 503, while a bad gateway or
+// gateway timeout can come after visma handled it, which is only safe to
+// send again if the request is idempotent.
+func isTransient(err error, idempotent bool) bool {
+	e, ok := err.(*RequestError)
+	if !ok {
+		return false
+	}
+	switch e.StatusCode {
+	case 429, 503:
+		return true
+	case 502, 504:
+		return idempotent
 	}
-	return json.Unmarshal(respData, respType)
+	return false
 }
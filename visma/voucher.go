@@ -1,6 +1,7 @@
 package visma
 
 import (
+	"encoding/json"
 	"fmt"
 	"izettle-daily-reports/util"
 	"time"
@@ -40,29 +41,62 @@ func (c *Client) Vouchers(fromDate util.Date, toDate util.Date, id ...string) ([
 	} else if len(id) == 2 {
 		resource = resource + "/" + id[0] + "/" + id[1]
 	}
-	page := 1
-	pageSize := 1000
+	query := NewQuery().
+		Filter("VoucherDate", Ge, fromDate).
+		Filter("VoucherDate", Le, toDate).
+		OrderBy("VoucherDate")
+	vouchers, err := c.vouchers(resource, query)
+	if err != nil {
+		return nil, err
+	}
+	// The server already filters by date, this only guards against the
+	// server ignoring the filter.
+	filtered := []Voucher{}
+	for _, v := range vouchers {
+		date := v.VoucherDate
+		if !date.Before(fromDate) && !date.After(toDate) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered, nil
+}
+
+// FindVouchers returns the vouchers of the fiscal year matching the query,
+// e.g. only the vouchers of a VoucherType.
+func (c *Client) FindVouchers(fiscalYearID string, query *Query) ([]Voucher, error) {
+	return c.vouchers("vouchers/"+fiscalYearID, query)
+}
+
+func (c *Client) vouchers(resource string, query *Query) ([]Voucher, error) {
 	vouchers := []Voucher{}
-	for {
-		resp := struct {
-			Meta Meta
-			Data []Voucher
-		}{}
-		err := c.GetRequestPage(resource, page, pageSize, &resp)
+	err := c.GetAllPages(resource, query, func(data []byte) error {
+		var page []Voucher
+		err := json.Unmarshal(data, &page)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		for _, v := range resp.Data {
-			date := v.VoucherDate
-			if !date.Before(fromDate) && !date.After(toDate) {
-				vouchers = append(vouchers, v)
-			}
+		vouchers = append(vouchers, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vouchers, nil
+}
+
+// VouchersInFiscalYears fetches the vouchers between the dates from each of
+// the fiscal years.
+func (c *Client) VouchersInFiscalYears(fromDate util.Date, toDate util.Date, years []FiscalYear) ([]Voucher, error) {
+	vouchers := []Voucher{}
+	for _, year := range years {
+		if !year.Overlaps(fromDate, toDate) {
+			continue
 		}
-		if resp.Meta.CurrentPage >= resp.Meta.TotalNumberOfPages {
-			break
+		v, err := c.Vouchers(fromDate, toDate, year.ID)
+		if err != nil {
+			return nil, err
 		}
-		page = resp.Meta.CurrentPage + 1
-		pageSize = resp.Meta.PageSize
+		vouchers = append(vouchers, v...)
 	}
 	return vouchers, nil
 }
@@ -110,6 +144,31 @@ type VoucherRow struct {
 	ProjectID          string     `url:"ProjectId,omitempty"`
 }
 
+// CostCenterItemID returns the cost center item of the dimension, 1 to 3
+func (r *VoucherRow) CostCenterItemID(dimension int) string {
+	switch dimension {
+	case 1:
+		return r.CostCenterItemID1
+	case 2:
+		return r.CostCenterItemID2
+	case 3:
+		return r.CostCenterItemID3
+	}
+	return ""
+}
+
+// SetCostCenterItemID sets the cost center item of the dimension, 1 to 3
+func (r *VoucherRow) SetCostCenterItemID(dimension int, id string) {
+	switch dimension {
+	case 1:
+		r.CostCenterItemID1 = id
+	case 2:
+		r.CostCenterItemID2 = id
+	case 3:
+		r.CostCenterItemID3 = id
+	}
+}
+
 type VoucherAttachment struct {
 	DocumentID    string   `url:"DocumentId,omitempty"`
 	DocumentType  int      `url:"DocumentType"`